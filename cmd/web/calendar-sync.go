@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"github.com/jjang65/booking-web-app/internal/handlers"
+	"time"
+)
+
+// calendarSyncInterval is how often bookings are imported from other platforms
+const calendarSyncInterval = time.Hour
+
+// syncCalendars imports the room calendars every calendarSyncInterval until ctx is done
+func syncCalendars(ctx context.Context) {
+	ticker := time.NewTicker(calendarSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		handlers.Repo.SyncRoomCalendars(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,23 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
+	"github.com/jjang65/booking-web-app/internal/cancellation"
 	"github.com/jjang65/booking-web-app/internal/config"
 	"github.com/jjang65/booking-web-app/internal/driver"
+	"github.com/jjang65/booking-web-app/internal/emails"
 	"github.com/jjang65/booking-web-app/internal/handlers"
 	"github.com/jjang65/booking-web-app/internal/helpers"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
 	"github.com/jjang65/booking-web-app/internal/render"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 )
 
-const portNumber = ":8081"
-
 var app config.AppConfig
 var session *scs.SessionManager
 var infoLog *log.Logger
@@ -25,43 +32,60 @@ var errorLog *log.Logger
 
 // main is the main application function
 func main() {
-	db, err := run()
+	settings, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
 
-	// defer to close MailChannel
-	// because defer close(app.MailChan) in run() func will close mail channel
-	// as soon as run() terminates, this needs to be here
-	// so when application closes, mail channel gets closed as well
-	defer close(app.MailChan)
+	// a command, like "migrate up", is run instead of the server
+	if len(settings.Args) > 0 {
+		if settings.Args[0] != "migrate" {
+			log.Fatalf("unknown command %q\n%s", settings.Args[0], migrateUsage)
+		}
+		if err := migrateCommand(settings, settings.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// Listen for mail
-	fmt.Println("Starting mail listener!")
-	listenForMail()
+	db, err := run(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Send an email when server starts
 	msg := models.MailData{
-		To:      "j@do.ca",
-		From:    "me@here.com",
+		To:      app.OwnerEmail,
+		From:    app.MailFrom,
 		Subject: "Server running",
-		Content: "Server is running!",
+		Text:    "Server is running!",
+	}
+	err = handlers.Repo.DB.InsertMail(context.Background(), msg)
+	if err != nil {
+		log.Println(err)
 	}
-	app.MailChan <- msg
 
-	fmt.Println(fmt.Sprintf("Starting application on port %s", portNumber))
-	//http.ListenAndServe(portNumber, nil)
+	ln, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(fmt.Sprintf("Starting application on %s", settings.Addr))
 
-	srv := &http.Server{
-		Addr:    portNumber,
-		Handler: routes(&app),
+	// Stop on Ctrl-C or when the process is told to terminate
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = serve(ctx, ln, routes(&app), db.SQL, settings.ShutdownTimeout)
+	if err != nil {
+		log.Fatal(err)
 	}
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	log.Println("server stopped")
 }
 
-func run() (*driver.DB, error) {
+func run(s config.Settings) (*driver.DB, error) {
 	// Store Reservation type in the session
 	// gob is standard library
 	gob.Register(models.Reservation{})
@@ -69,12 +93,7 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 
-	// Mail channeling
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	// Change this to ture when in production
-	app.InProduction = false
+	app.InProduction = s.InProduction
 
 	// Setup loggers
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -83,18 +102,56 @@ func run() (*driver.DB, error) {
 	app.ErrorLog = errorLog
 
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = s.Session.Lifetime
 	session.Cookie.Persist = true // Session will persist even after closing a tab
 	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
+	session.Cookie.Secure = s.Session.SecureCookie || app.InProduction
 
 	app.Session = session
 
+	// Key for signing the tokens in emailed links; without a fixed key, links stop working on restart
+	app.SecretKey = []byte(s.SecretKey)
+	if len(app.SecretKey) == 0 {
+		app.SecretKey = make([]byte, 32)
+		if _, err := rand.Read(app.SecretKey); err != nil {
+			return nil, err
+		}
+		log.Println("SECRET_KEY is not set, using a random key")
+	}
+	app.BaseURL = s.BaseURL
+	app.PropertyAddress = s.PropertyAddress
+
+	gateway, err := payments.New(s.PaymentGateway)
+	if err != nil {
+		return nil, err
+	}
+	app.Payments = gateway
+	if s.PaymentGateway == payments.GatewayFake {
+		log.Println("Using the fake payment gateway; no cards will be charged")
+	}
+
+	app.Cancellation = cancellation.Policy{
+		FreeDays:          s.Cancellation.FreeDays,
+		LateRefundPercent: s.Cancellation.LateRefundPercent,
+	}
+
+	err = setupMail(s.Mail)
+	if err != nil {
+		return nil, err
+	}
+
+	// a query that runs longer than this is given up on, so a stuck database can't hang every request
+	app.DBTimeout = s.DB.QueryTimeout
+
 	// Connect to db
 	log.Println("connecting to db")
-	db, err := driver.ConnectSQL("host=172.18.0.2 port=5432 dbname=bookings user=root password=root")
+	db, err := driver.ConnectSQL(s.DB.DSN(), driver.Pool{
+		MaxOpenConns:    s.DB.MaxOpenConns,
+		MaxIdleConns:    s.DB.MaxIdleConns,
+		ConnMaxLifetime: s.DB.ConnMaxLifetime,
+	})
 	if err != nil {
-		log.Fatal("cannot connect to db")
+		return nil, fmt.Errorf("cannot connect to db: %w", err)
 	}
 	log.Println("connected to db")
 
@@ -109,10 +166,18 @@ func run() (*driver.DB, error) {
 	// Assign templateCache to app.TemplateCache in app config
 	app.TemplateCache = tc
 
-	// Set app.UseCache to be false, meaning no templateCache will be used
-	//If set to ture, templateCache will be created, newly added temp ate won't be rendered
-	// unless app server is compiled again
-	app.UseCache = false
+	// Parse the email templates up front, so a broken one stops the server from starting
+	// rather than the mail about a booking from being sent
+	app.EmailTemplates, err = emails.CreateTemplateCache()
+	if err != nil {
+		log.Println(err)
+		log.Fatal("cannot create email template cache")
+		return nil, err
+	}
+
+	// When app.UseCache is false, no templateCache will be used and templates are parsed on every request.
+	// If set to true, newly added templates won't be rendered unless the app server is restarted
+	app.UseCache = s.UseCache
 
 	// Passing app reference to use app config in the render package
 	render.NewRenderer(&app)
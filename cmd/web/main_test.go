@@ -1,10 +1,22 @@
 package main
 
-import "testing"
+import (
+	"github.com/jjang65/booking-web-app/internal/config"
+	"os"
+	"testing"
+)
 
+// TestRun needs a database, which is configured like the server's, e.g. with DB_HOST and DB_USER
 func TestRun(t *testing.T) {
-	_, err := run()
+	settings, err := config.Load(nil, os.Getenv)
 	if err != nil {
-		t.Error("failed run()")
+		t.Skip("no database is configured:", err)
 	}
+
+	db, err := run(settings)
+	if err != nil {
+		t.Error("failed run():", err)
+		return
+	}
+	db.SQL.Close()
 }
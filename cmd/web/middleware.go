@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"github.com/jjang65/booking-web-app/internal/handlers"
 	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/render"
 	"github.com/justinas/nosurf"
 	"net/http"
 )
@@ -18,6 +21,9 @@ func WriteToConsole(next http.Handler) http.Handler {
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 
+	// api clients don't have a form to carry the token, and don't log in with cookies
+	csrfHandler.ExemptGlob("/api/*")
+
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,                 // Only server side can access this cookie; no other client side JS can't access
 		Path:     "/",                  // entire site
@@ -32,15 +38,51 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// Auth makes sure the user is logged in, and puts the user into the request context
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(
-		// create own handler func where to call IsAuthenticate()
-		// then call next.ServeHTTP() to continue
 		func(w http.ResponseWriter, r *http.Request) {
 			if !helpers.IsAuthenticated(r) {
 				session.Put(r.Context(), "error", "Please login")
 				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			u, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+			if err != nil || !u.Active {
+				// the user is gone or deactivated, so the session is no good anymore
+				if err != nil {
+					app.ErrorLog.Println("Auth: can't load user:", err)
+				}
+				session.Remove(r.Context(), "user_id")
+				session.Put(r.Context(), "error", "Please login")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(helpers.ContextWithUser(r.Context(), u)))
+		})
+}
+
+// RequireAccessLevel only lets through users with at least the given access level; it must run after Auth
+func RequireAccessLevel(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := helpers.AuthUser(r)
+			if !ok || u.AccessLevel < level {
+				forbidden(w, r)
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
+	}
+}
+
+// forbidden renders the 403 page
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	err := render.Template(w, r, "forbidden.page.tmpl", &models.TemplateData{})
+	if err != nil {
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+	}
 }
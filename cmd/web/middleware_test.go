@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/models"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -44,3 +47,84 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestAuth(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+		expectedUserID     int
+	}{
+		{"not logged in", 0, http.StatusSeeOther, 0},
+		{"logged in", 2, http.StatusOK, 2},
+		{"user no longer exists", 1000, http.StatusSeeOther, 0},
+		{"user deactivated", 4, http.StatusSeeOther, 0},
+	}
+
+	for _, e := range tests {
+		var gotUser models.User
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUser, _ = helpers.AuthUser(r)
+		})
+
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		if e.userID > 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+
+		rr := httptest.NewRecorder()
+		Auth(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if gotUser.ID != e.expectedUserID {
+			t.Errorf("%s: expected user %d in context but got %d", e.name, e.expectedUserID, gotUser.ID)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && rr.Header().Get("Location") != "/user/login" {
+			t.Errorf("%s: expected redirect to login but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRequireAccessLevel(t *testing.T) {
+	var tests = []struct {
+		name               string
+		user               *models.User
+		level              int
+		expectedStatusCode int
+	}{
+		{"no user", nil, models.AccessLevelViewer, http.StatusForbidden},
+		{"viewer on viewer route", &models.User{ID: 3, AccessLevel: models.AccessLevelViewer}, models.AccessLevelViewer, http.StatusOK},
+		{"viewer on front desk route", &models.User{ID: 3, AccessLevel: models.AccessLevelViewer}, models.AccessLevelFrontDesk, http.StatusForbidden},
+		{"front desk on front desk route", &models.User{ID: 2, AccessLevel: models.AccessLevelFrontDesk}, models.AccessLevelFrontDesk, http.StatusOK},
+		{"front desk on owner route", &models.User{ID: 2, AccessLevel: models.AccessLevelFrontDesk}, models.AccessLevelOwner, http.StatusForbidden},
+		{"owner on owner route", &models.User{ID: 1, AccessLevel: models.AccessLevelOwner}, models.AccessLevelOwner, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		var called bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+
+		req := httptest.NewRequest("GET", "/admin/rooms", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		if e.user != nil {
+			ctx = helpers.ContextWithUser(ctx, *e.user)
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		RequireAccessLevel(e.level)(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if called != (e.expectedStatusCode == http.StatusOK) {
+			t.Errorf("%s: next handler called is %t", e.name, called)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/driver"
+	"github.com/jjang65/booking-web-app/internal/migrate"
+	"github.com/jjang65/booking-web-app/migrations"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// migrateUsage lists the migrate commands
+const migrateUsage = "usage: bookings [flags] migrate up | down [steps] | to <version> | status"
+
+// migrateCommand connects to the configured database and runs a migrate command, like "up"
+func migrateCommand(s config.Settings, args []string) error {
+	if err := checkMigrateArgs(args); err != nil {
+		return err
+	}
+
+	db, err := driver.ConnectSQL(s.DB.DSN(), driver.Pool{
+		MaxOpenConns:    s.DB.MaxOpenConns,
+		MaxIdleConns:    s.DB.MaxIdleConns,
+		ConnMaxLifetime: s.DB.ConnMaxLifetime,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot connect to db: %w", err)
+	}
+	defer db.SQL.Close()
+
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+	m.Log = log.New(os.Stdout, "", 0)
+
+	return runMigrate(context.Background(), m, args, os.Stdout)
+}
+
+// checkMigrateArgs checks a migrate command before anything is connected to
+func checkMigrateArgs(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up", "status":
+		if len(args) == 1 {
+			return nil
+		}
+	case "down":
+		if len(args) == 1 {
+			return nil
+		}
+		if steps, err := strconv.Atoi(args[1]); len(args) == 2 && err == nil && steps > 0 {
+			return nil
+		}
+	case "to":
+		if len(args) == 2 {
+			return nil
+		}
+	}
+	return errors.New(migrateUsage)
+}
+
+// runMigrate runs the migrate command args with m, and writes the status to out
+func runMigrate(ctx context.Context, m *migrate.Migrator, args []string, out io.Writer) error {
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, _ = strconv.Atoi(args[1])
+		}
+		return m.Down(ctx, steps)
+
+	case "to":
+		return m.To(ctx, args[1])
+
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Status\tVersion\tName")
+		for _, s := range status {
+			state, name := "pending", s.Name
+			if s.Applied {
+				state = "applied"
+			}
+			if name == "" {
+				name = "(no migration file)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", state, s.Version, name)
+		}
+		return w.Flush()
+	}
+
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCheckMigrateArgs(t *testing.T) {
+	var tests = []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"up"}, true},
+		{[]string{"status"}, true},
+		{[]string{"down"}, true},
+		{[]string{"down", "3"}, true},
+		{[]string{"to", "20220425032022"}, true},
+		{[]string{"to", "0"}, true},
+		{nil, false},
+		{[]string{"sideways"}, false},
+		{[]string{"up", "now"}, false},
+		{[]string{"down", "all"}, false},
+		{[]string{"down", "0"}, false},
+		{[]string{"to"}, false},
+	}
+
+	for _, e := range tests {
+		err := checkMigrateArgs(e.args)
+		if e.valid && err != nil {
+			t.Errorf("%q: unexpected error %s", e.args, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%q: expected the usage", e.args)
+		}
+	}
+}
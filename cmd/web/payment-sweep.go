@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"github.com/jjang65/booking-web-app/internal/handlers"
+	"time"
+)
+
+// paymentSweepInterval is how often payments the gateway didn't answer for are checked on
+const paymentSweepInterval = 5 * time.Minute
+
+// sweepPayments settles the payments left pending every paymentSweepInterval until ctx is done
+func sweepPayments(ctx context.Context) {
+	ticker := time.NewTicker(paymentSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		handlers.Repo.SweepPendingPayments(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jjang65/booking-web-app/internal/config"
 	"github.com/jjang65/booking-web-app/internal/handlers"
+	"github.com/jjang65/booking-web-app/internal/models"
 	"net/http"
 )
 
@@ -24,8 +25,13 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendar)
+
+	// Old room urls, from before rooms came from the database
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -42,17 +48,76 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+
+	// Guests manage their own reservations through the link in their confirmation email
+	mux.Get("/reservations/manage", handlers.Repo.ShowManageReservation)
+	mux.Post("/reservations/cancel", handlers.Repo.PostCancelReservation)
+
+	// Versioned JSON api, for the mobile app and partner sites
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/rooms/{id}", handlers.Repo.APIRoom)
+		mux.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APICreateReservation)
+		mux.Get("/reservations/{code}", handlers.Repo.APIReservation)
+	})
 
 	// Protect routes starting "admin"
 	mux.Route("/admin", func(mux chi.Router) {
 		// call Auth middleware
-		//mux.Use(Auth)
+		mux.Use(Auth)
+
+		// Viewers can look at reservations, but not change anything
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccessLevel(models.AccessLevelViewer))
+
+			// GET /admin/dashboard
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+			mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.Get("/reservations-search", handlers.Repo.AdminSearchReservations)
+			mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+		})
+
+		// Front desk staff can change reservations and owner blocks
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccessLevel(models.AccessLevelFrontDesk))
+
+			mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+			mux.Post("/reservations/{src}/status", handlers.Repo.AdminUpdateReservationStatus)
+			mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+			mux.Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminDeleteReservation)
+		})
+
+		// Only owners can manage rooms and staff users
+		mux.Group(func(mux chi.Router) {
+			mux.Use(RequireAccessLevel(models.AccessLevelOwner))
+
+			mux.Get("/rooms", handlers.Repo.AdminRooms)
+			mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+			mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+			mux.Post("/rooms/{id}/ical-token", handlers.Repo.AdminRotateRoomICalToken)
+			mux.Post("/rooms/{id}/ical-import", handlers.Repo.AdminImportRoomCalendar)
+			mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+			mux.Post("/rooms/{id}/rates/{rateID}/delete", handlers.Repo.AdminDeleteRoomRate)
+
+			mux.Get("/users", handlers.Repo.AdminUsers)
+			mux.Get("/users/{id}", handlers.Repo.AdminShowUser)
+			mux.Post("/users/{id}", handlers.Repo.AdminPostShowUser)
 
-		// GET /admin/dashboard
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+			mux.Get("/mail", handlers.Repo.AdminMail)
+			mux.Get("/mail/{id}", handlers.Repo.AdminShowMail)
+			mux.Post("/mail/{id}/resend", handlers.Repo.AdminResendMail)
+		})
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -1,59 +1,36 @@
 package main
 
 import (
-	"fmt"
-	"github.com/jjang65/booking-web-app/internal/models"
-	mail "github.com/xhit/go-simple-mail/v2"
-	"io/ioutil"
-	"log"
-	"strings"
-	"time"
+	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/mailer"
 )
 
-func listenForMail() {
-	// runs in the background
-	go func() {
-		// Listen for mail channel
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
-		}
-	}()
-}
-
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	client, err := server.Connect()
+// setupMail chooses the mail transport and addresses from the settings. By default, mail
+// goes to an SMTP server on localhost:1025 without authentication, such as MailHog, unsigned.
+func setupMail(s config.MailSettings) error {
+	var err error
+	app.Mailer, err = mailer.New(mailer.Config{
+		Transport:  s.Transport,
+		Host:       s.SMTPHost,
+		Port:       s.SMTPPort,
+		Username:   s.SMTPUsername,
+		Password:   s.SMTPPassword,
+		Encryption: s.SMTPEncryption,
+		Dir:        s.Dir,
+		DKIM: mailer.DKIMConfig{
+			Domain:   s.DKIMDomain,
+			Selector: s.DKIMSelector,
+			KeyFile:  s.DKIMKeyFile,
+		},
+	})
 	if err != nil {
-		errorLog.Println(err)
-	}
-
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		// Send email with template
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Println(err)
-		}
-
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
+		return err
 	}
 
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email sent")
-	}
+	app.MailFrom = s.From
+	app.OwnerEmail = s.OwnerEmail
+	app.MailReplyTo = s.ReplyTo
+	app.MailReturnPath = s.ReturnPath
+	app.MailListUnsubscribe = s.ListUnsubscribe
+	return nil
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"github.com/jjang65/booking-web-app/internal/handlers"
+	"github.com/jjang65/booking-web-app/internal/outbox"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serve runs the server on ln, with the mail, calendar and payment workers in the background, until ctx is done.
+// Then it shuts down in order: it stops taking connections and waits for the requests in flight, stops
+// the workers, sends the mail that is due and closes db. All of that has to happen within timeout;
+// whatever isn't done by then is cut off, and db is left open if the workers haven't stopped, since
+// they may still be using it.
+func serve(ctx context.Context, ln net.Listener, handler http.Handler, db io.Closer, timeout time.Duration) error {
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	// the mail being sent when the workers stop is given until the shutdown deadline
+	delivery, stopDelivery := context.WithCancel(context.Background())
+	defer stopDelivery()
+
+	mail := outbox.New(handlers.Repo.DB, app.Mailer.Send, app.ErrorLog)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		mail.Run(workers, delivery)
+	}()
+	go func() {
+		defer wg.Done()
+		syncCalendars(workers)
+	}()
+	go func() {
+		defer wg.Done()
+		sweepPayments(workers)
+	}()
+
+	srv := &http.Server{Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		app.ErrorLog.Println("server stopped:", err)
+	case <-ctx.Done():
+		app.InfoLog.Println("shutting down")
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		<-shutdown.Done()
+		stopDelivery()
+	}()
+
+	if err == nil {
+		if shutdownErr := srv.Shutdown(shutdown); shutdownErr != nil {
+			app.ErrorLog.Println("requests still running at shutdown:", shutdownErr)
+			srv.Close()
+		}
+	}
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	if !waitDone(shutdown, stopped) {
+		app.ErrorLog.Println("background workers still running at shutdown; leaving the database open")
+		return err
+	}
+
+	// mail written while the server was draining goes out now, rather than at the next start
+	if flushErr := mail.Flush(shutdown); flushErr != nil {
+		app.ErrorLog.Println("mail left in the outbox at shutdown:", flushErr)
+	}
+	if closeErr := db.Close(); closeErr != nil {
+		app.ErrorLog.Println("closing the database:", closeErr)
+	}
+	return err
+}
+
+// waitDone waits until done is closed or ctx is done, and says whether done was closed
+func waitDone(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+	// both may be ready, and select picks either
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"github.com/jjang65/booking-web-app/internal/mailer"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeDB records when it is closed
+type fakeDB struct {
+	closed chan struct{}
+}
+
+func (db *fakeDB) Close() error {
+	close(db.closed)
+	return nil
+}
+
+// startServe runs serve on a free port with handler, and returns its address and result
+func startServe(t *testing.T, ctx context.Context, handler http.Handler, db *fakeDB, timeout time.Duration) (string, chan error) {
+	var err error
+	app.Mailer, err = mailer.New(mailer.Config{Transport: mailer.TransportMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- serve(ctx, ln, handler, db, timeout)
+	}()
+	return ln.Addr().String(), result
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &fakeDB{closed: make(chan struct{})}
+	addr, result := startServe(t, ctx, handler, db, 5*time.Second)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	// stop the server while a request is in flight, as a signal would
+	<-started
+	cancel()
+
+	// new connections are turned away
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the server still takes connections after shutting down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// but the request in flight is finished before anything is closed
+	select {
+	case <-result:
+		t.Fatal("serve returned while a request was in flight")
+	case <-db.closed:
+		t.Fatal("the database was closed while a request was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("expected the request in flight to finish, got %q", got)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve didn't return after shutting down")
+	}
+
+	select {
+	case <-db.closed:
+	default:
+		t.Error("expected the database to be closed")
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-stuck
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &fakeDB{closed: make(chan struct{})}
+	addr, result := startServe(t, ctx, handler, db, 50*time.Millisecond)
+
+	go http.Get("http://" + addr)
+	<-started
+	cancel()
+
+	// a request that doesn't finish in time is cut off, and the rest of the shutdown goes on; the
+	// workers have no time left to stop in, so whether the database is closed depends on them
+	select {
+	case <-result:
+	case <-time.After(time.Second):
+		t.Fatal("serve didn't give up on the stuck request")
+	}
+}
+
+func TestWaitDone(t *testing.T) {
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	if waitDone(expired, done) {
+		t.Error("expected waitDone to give up when the context is done first")
+	}
+
+	// when both are ready, the workers having stopped wins
+	close(done)
+	for i := 0; i < 100; i++ {
+		if !waitDone(expired, done) {
+			t.Fatal("expected waitDone to see that done was closed")
+		}
+	}
+}
@@ -1,12 +1,40 @@
 package main
 
 import (
+	"encoding/gob"
+	"github.com/alexedwards/scs/v2"
+	"github.com/jjang65/booking-web-app/internal/handlers"
+	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"github.com/jjang65/booking-web-app/internal/render"
+	"log"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
+	gob.Register(models.User{})
+
+	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.InfoLog = infoLog
+	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	app.ErrorLog = errorLog
+
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	session.Cookie.Persist = true
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	session.Cookie.Secure = false
+
+	app.Session = session
+	app.Payments = payments.NewFakeGateway()
+
+	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
 
 	os.Exit(m.Run())
 }
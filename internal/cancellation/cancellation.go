@@ -0,0 +1,50 @@
+package cancellation
+
+import (
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"time"
+)
+
+// Policy decides whether guests can cancel their own reservations, and how much of what they paid they get back
+type Policy struct {
+	// FreeDays is how many days before arrival a guest can cancel for a full refund
+	FreeDays int
+	// LateRefundPercent is the share of the payment refunded when a guest cancels later than that
+	LateRefundPercent int
+}
+
+// Terms are what a guest gets if they cancel a reservation now; amounts are in cents
+type Terms struct {
+	// Allowed is false once the stay has started, or when the reservation can't be cancelled at all
+	Allowed bool
+	// Free says whether the whole payment is refunded
+	Free bool
+	// FreeUntil is the moment free cancellation ends
+	FreeUntil time.Time
+	Paid      int
+	Refund    int
+}
+
+// Terms works out the terms for cancelling res at now, when paid cents have been paid and not refunded
+func (p Policy) Terms(res models.Reservation, paid int, now time.Time) Terms {
+	y, m, d := res.StartDate.Date()
+	arrival := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	t := Terms{
+		FreeUntil: arrival.AddDate(0, 0, -p.FreeDays),
+		Paid:      paid,
+	}
+	if !lifecycle.CanTransition(res.Status, models.ReservationCancelled) || !now.Before(arrival) {
+		return t
+	}
+
+	t.Allowed = true
+	if now.Before(t.FreeUntil) {
+		t.Free = true
+		t.Refund = paid
+	} else {
+		t.Refund = paid * p.LateRefundPercent / 100
+	}
+	return t
+}
@@ -0,0 +1,49 @@
+package cancellation
+
+import (
+	"github.com/jjang65/booking-web-app/internal/models"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Terms(t *testing.T) {
+	p := Policy{FreeDays: 7, LateRefundPercent: 50}
+	res := models.Reservation{
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+		Status:    models.ReservationConfirmed,
+	}
+	cancelled := res
+	cancelled.Status = models.ReservationCancelled
+	checkedIn := res
+	checkedIn.Status = models.ReservationCheckedIn
+
+	var tests = []struct {
+		name    string
+		res     models.Reservation
+		now     time.Time
+		allowed bool
+		free    bool
+		refund  int
+	}{
+		{"well before arrival", res, time.Date(2050, 5, 1, 12, 0, 0, 0, time.UTC), true, true, 20000},
+		{"just before free cancellation ends", res, time.Date(2050, 6, 2, 23, 59, 0, 0, time.UTC), true, true, 20000},
+		{"after free cancellation ends", res, time.Date(2050, 6, 3, 0, 0, 0, 0, time.UTC), true, false, 10000},
+		{"day before arrival", res, time.Date(2050, 6, 9, 18, 0, 0, 0, time.UTC), true, false, 10000},
+		{"on arrival day", res, time.Date(2050, 6, 10, 8, 0, 0, 0, time.UTC), false, false, 0},
+		{"already cancelled", cancelled, time.Date(2050, 5, 2, 0, 0, 0, 0, time.UTC), false, false, 0},
+		{"checked in early", checkedIn, time.Date(2050, 5, 2, 0, 0, 0, 0, time.UTC), false, false, 0},
+	}
+
+	for _, e := range tests {
+		terms := p.Terms(e.res, 20000, e.now)
+		if terms.Allowed != e.allowed || terms.Free != e.free || terms.Refund != e.refund {
+			t.Errorf("%s: got %+v", e.name, terms)
+		}
+	}
+
+	// without free days, cancelling is free right up to arrival
+	if terms := (Policy{}).Terms(res, 20000, time.Date(2050, 6, 9, 23, 0, 0, 0, time.UTC)); !terms.Free || terms.Refund != 20000 {
+		t.Errorf("wrong terms for a policy without free days: %+v", terms)
+	}
+}
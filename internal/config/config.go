@@ -2,9 +2,13 @@ package config
 
 import (
 	"github.com/alexedwards/scs/v2"
-	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/cancellation"
+	"github.com/jjang65/booking-web-app/internal/emails"
+	"github.com/jjang65/booking-web-app/internal/mailer"
+	"github.com/jjang65/booking-web-app/internal/payments"
 	"html/template"
 	"log"
+	"time"
 )
 
 // AppConfig holds the application config
@@ -15,5 +19,28 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	// DBTimeout bounds every database query
+	DBTimeout time.Duration
+	// SecretKey signs the tokens we put in emailed links
+	SecretKey []byte
+	// BaseURL is the public address of the site, used to build links in emails
+	BaseURL string
+	// PropertyAddress is where guests stay, given as the location in their calendar invites
+	PropertyAddress string
+	// Payments takes the guests' card payments
+	Payments payments.PaymentGateway
+	// Cancellation is the policy for guests cancelling their own reservations
+	Cancellation cancellation.Policy
+	// EmailTemplates are the parsed templates of the emails we send
+	EmailTemplates emails.Cache
+	// Mailer delivers the mail in the outbox
+	Mailer mailer.Mailer
+	// MailFrom is the address our mail comes from, and OwnerEmail the address notifications go to
+	MailFrom   string
+	OwnerEmail string
+	// MailReplyTo, MailReturnPath and MailListUnsubscribe go on all the mail we send to guests;
+	// any of them may be empty
+	MailReplyTo         string
+	MailReturnPath      string
+	MailListUnsubscribe string
 }
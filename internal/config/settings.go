@@ -0,0 +1,331 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/mailer"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"time"
+)
+
+// Settings are the values the server is started with. Load fills them from, in increasing order of
+// precedence, the defaults, an optional YAML config file, environment variables and command-line flags.
+type Settings struct {
+	// Addr is the address the server listens on
+	Addr string `yaml:"addr"`
+	// BaseURL is the public address of the site; it defaults to localhost on the port of Addr
+	BaseURL string `yaml:"base_url"`
+	// ShutdownTimeout is how long the server has to finish its requests and mail when it is stopped
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// InProduction turns on secure cookies and requires a secret key
+	InProduction bool `yaml:"in_production"`
+	// UseCache parses the page templates once at startup, instead of on every request
+	UseCache bool `yaml:"use_cache"`
+	// SecretKey signs the tokens in emailed links; without one, a random key is used
+	SecretKey       string `yaml:"secret_key"`
+	PropertyAddress string `yaml:"property_address"`
+	// PaymentGateway is the gateway that takes card payments
+	PaymentGateway string `yaml:"payment_gateway"`
+
+	DB           DBSettings           `yaml:"db"`
+	Session      SessionSettings      `yaml:"session"`
+	Mail         MailSettings         `yaml:"mail"`
+	Cancellation CancellationSettings `yaml:"cancellation"`
+
+	// Args are the arguments after the flags, like a command to run instead of the server
+	Args []string `yaml:"-"`
+}
+
+// DBSettings are the database connection and pool settings
+type DBSettings struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Name     string `yaml:"name"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	SSLMode  string `yaml:"sslmode"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// QueryTimeout bounds every database query
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+// SessionSettings are the settings of the session cookie
+type SessionSettings struct {
+	Lifetime time.Duration `yaml:"lifetime"`
+	// SecureCookie sends the cookie over https only; it is always on in production
+	SecureCookie bool `yaml:"secure_cookie"`
+}
+
+// CancellationSettings are the policy for guests cancelling their own reservations
+type CancellationSettings struct {
+	// FreeDays is how many days before arrival a guest can cancel for a full refund
+	FreeDays int `yaml:"free_days"`
+	// LateRefundPercent is the share of the payment refunded when a guest cancels later than that
+	LateRefundPercent int `yaml:"late_refund_percent"`
+}
+
+// MailSettings choose how mail is delivered and the addresses it goes out with
+type MailSettings struct {
+	Transport string `yaml:"transport"`
+	Dir       string `yaml:"dir"`
+
+	SMTPHost       string `yaml:"smtp_host"`
+	SMTPPort       int    `yaml:"smtp_port"`
+	SMTPUsername   string `yaml:"smtp_username"`
+	SMTPPassword   string `yaml:"smtp_password"`
+	SMTPEncryption string `yaml:"smtp_encryption"`
+
+	DKIMDomain   string `yaml:"dkim_domain"`
+	DKIMSelector string `yaml:"dkim_selector"`
+	DKIMKeyFile  string `yaml:"dkim_key_file"`
+
+	From            string `yaml:"from"`
+	OwnerEmail      string `yaml:"owner_email"`
+	ReplyTo         string `yaml:"reply_to"`
+	ReturnPath      string `yaml:"return_path"`
+	ListUnsubscribe string `yaml:"list_unsubscribe"`
+}
+
+// DefaultSettings are the settings of a development server, with a local database and MailHog
+func DefaultSettings() Settings {
+	return Settings{
+		Addr:            ":8081",
+		ShutdownTimeout: 30 * time.Second,
+		PropertyAddress: "Fort Smythe Bed and Breakfast",
+		PaymentGateway:  payments.GatewayFake,
+		DB: DBSettings{
+			Host:            "localhost",
+			Port:            5432,
+			Name:            "bookings",
+			SSLMode:         "disable",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 5 * time.Minute,
+			QueryTimeout:    3 * time.Second,
+		},
+		Session: SessionSettings{
+			Lifetime: 24 * time.Hour,
+		},
+		Mail: MailSettings{
+			Transport:      mailer.TransportSMTP,
+			Dir:            "./mail",
+			SMTPHost:       "localhost",
+			SMTPPort:       1025,
+			SMTPEncryption: mailer.EncryptionNone,
+			From:           "me@here.com",
+		},
+		Cancellation: CancellationSettings{
+			FreeDays:          7,
+			LateRefundPercent: 50,
+		},
+	}
+}
+
+// Load reads the settings from the config file, the environment and args, the command-line flags.
+// The config file is given by the -config flag or the CONFIG_FILE environment variable.
+func Load(args []string, getenv func(string) string) (Settings, error) {
+	s := DefaultSettings()
+
+	fs := flag.NewFlagSet("booking", flag.ContinueOnError)
+	configFile := getenv("CONFIG_FILE")
+	fs.StringVar(&configFile, "config", configFile, "the YAML config `file` ($CONFIG_FILE)")
+	env := s.flags(fs)
+
+	// the flags are parsed once to find the config file, and again on top of the file and environment
+	if err := fs.Parse(args); err != nil {
+		return s, err
+	}
+	s = DefaultSettings()
+
+	if configFile != "" {
+		if err := s.readFile(configFile); err != nil {
+			return s, err
+		}
+	}
+
+	for name, key := range env {
+		if v := getenv(key); v != "" {
+			if err := fs.Set(name, v); err != nil {
+				return s, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return s, err
+	}
+	s.Args = fs.Args()
+
+	if s.BaseURL == "" && strings.Contains(s.Addr, ":") {
+		s.BaseURL = "http://localhost" + s.Addr[strings.LastIndex(s.Addr, ":"):]
+	}
+	if s.Mail.OwnerEmail == "" {
+		s.Mail.OwnerEmail = s.Mail.From
+	}
+
+	return s, s.Validate()
+}
+
+// flags defines a flag for every setting on fs, and returns the environment variable of each flag
+func (s *Settings) flags(fs *flag.FlagSet) map[string]string {
+	env := make(map[string]string)
+	str := func(p *string, name, key, usage string) {
+		fs.StringVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+	num := func(p *int, name, key, usage string) {
+		fs.IntVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+	boolean := func(p *bool, name, key, usage string) {
+		fs.BoolVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+	duration := func(p *time.Duration, name, key, usage string) {
+		fs.DurationVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+
+	str(&s.Addr, "addr", "ADDR", "the address to listen on")
+	str(&s.BaseURL, "base-url", "BASE_URL", "the public address of the site")
+	duration(&s.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for requests and mail when stopping")
+	boolean(&s.InProduction, "production", "IN_PRODUCTION", "run in production")
+	boolean(&s.UseCache, "use-cache", "USE_CACHE", "parse the page templates once at startup")
+	str(&s.SecretKey, "secret-key", "SECRET_KEY", "the key that signs emailed links")
+	str(&s.PropertyAddress, "property-address", "PROPERTY_ADDRESS", "the address of the property")
+	str(&s.PaymentGateway, "payment-gateway", "PAYMENT_GATEWAY", "the payment gateway: fake")
+
+	str(&s.DB.Host, "db-host", "DB_HOST", "the database host")
+	num(&s.DB.Port, "db-port", "DB_PORT", "the database port")
+	str(&s.DB.Name, "db-name", "DB_NAME", "the database name")
+	str(&s.DB.User, "db-user", "DB_USER", "the database user")
+	str(&s.DB.Password, "db-password", "DB_PASSWORD", "the database password")
+	str(&s.DB.SSLMode, "db-sslmode", "DB_SSLMODE", "the database ssl mode")
+	num(&s.DB.MaxOpenConns, "db-max-open-conns", "DB_MAX_OPEN_CONNS", "the most open database connections")
+	num(&s.DB.MaxIdleConns, "db-max-idle-conns", "DB_MAX_IDLE_CONNS", "the most idle database connections")
+	duration(&s.DB.ConnMaxLifetime, "db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "how long a database connection is reused")
+	duration(&s.DB.QueryTimeout, "db-query-timeout", "DB_QUERY_TIMEOUT", "how long a query may run")
+
+	duration(&s.Session.Lifetime, "session-lifetime", "SESSION_LIFETIME", "how long a session lasts")
+	boolean(&s.Session.SecureCookie, "session-secure-cookie", "SESSION_SECURE_COOKIE", "send the session cookie over https only")
+
+	str(&s.Mail.Transport, "mail-transport", "MAIL_TRANSPORT", "how mail is delivered: smtp, file or memory")
+	str(&s.Mail.Dir, "mail-dir", "MAIL_DIR", "the directory of the file transport")
+	str(&s.Mail.SMTPHost, "smtp-host", "SMTP_HOST", "the SMTP host")
+	num(&s.Mail.SMTPPort, "smtp-port", "SMTP_PORT", "the SMTP port")
+	str(&s.Mail.SMTPUsername, "smtp-username", "SMTP_USERNAME", "the SMTP username")
+	str(&s.Mail.SMTPPassword, "smtp-password", "SMTP_PASSWORD", "the SMTP password")
+	str(&s.Mail.SMTPEncryption, "smtp-encryption", "SMTP_ENCRYPTION", "the SMTP encryption: none, starttls or tls")
+	str(&s.Mail.DKIMDomain, "dkim-domain", "DKIM_DOMAIN", "the domain mail is signed for")
+	str(&s.Mail.DKIMSelector, "dkim-selector", "DKIM_SELECTOR", "the DKIM selector")
+	str(&s.Mail.DKIMKeyFile, "dkim-key-file", "DKIM_KEY_FILE", "the DKIM private key")
+	str(&s.Mail.From, "mail-from", "MAIL_FROM", "the address mail comes from")
+	str(&s.Mail.OwnerEmail, "owner-email", "OWNER_EMAIL", "the address notifications go to; defaults to the from address")
+	str(&s.Mail.ReplyTo, "mail-reply-to", "MAIL_REPLY_TO", "the Reply-To of mail to guests")
+	str(&s.Mail.ReturnPath, "mail-return-path", "MAIL_RETURN_PATH", "where bounces go")
+	str(&s.Mail.ListUnsubscribe, "mail-list-unsubscribe", "MAIL_LIST_UNSUBSCRIBE", "the List-Unsubscribe of mail to guests")
+
+	num(&s.Cancellation.FreeDays, "cancel-free-days", "CANCEL_FREE_DAYS", "how many days before arrival guests can cancel for free")
+	num(&s.Cancellation.LateRefundPercent, "cancel-late-refund-percent", "CANCEL_LATE_REFUND_PERCENT", "the percent refunded on later cancellations")
+
+	return env
+}
+
+// readFile reads the YAML config file on top of s; unknown keys are an error, so typos don't go unnoticed
+func (s *Settings) readFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Validate checks that everything the server needs is set, and reports all that is missing at once
+func (s Settings) Validate() error {
+	var problems []string
+	missing := func(what, flag, key string) {
+		problems = append(problems, fmt.Sprintf("%s is not set (-%s or $%s)", what, flag, key))
+	}
+
+	if !strings.Contains(s.Addr, ":") {
+		problems = append(problems, fmt.Sprintf("the listen address %q has no port", s.Addr))
+	}
+	if s.ShutdownTimeout <= 0 {
+		problems = append(problems, "the shutdown timeout must be positive")
+	}
+	if s.InProduction && s.SecretKey == "" {
+		missing("the secret key, which production requires,", "secret-key", "SECRET_KEY")
+	}
+	if _, err := payments.New(s.PaymentGateway); err != nil {
+		problems = append(problems, err.Error())
+	} else if s.InProduction && s.PaymentGateway == payments.GatewayFake {
+		// anyone could book with the test tokens, which the booking form shows
+		problems = append(problems, "the fake payment gateway can't be used in production (-payment-gateway or $PAYMENT_GATEWAY)")
+	}
+
+	if s.DB.Host == "" {
+		missing("the database host", "db-host", "DB_HOST")
+	}
+	if s.DB.Name == "" {
+		missing("the database name", "db-name", "DB_NAME")
+	}
+	if s.DB.User == "" {
+		missing("the database user", "db-user", "DB_USER")
+	}
+	if s.DB.Port <= 0 {
+		problems = append(problems, fmt.Sprintf("the database port %d is not valid", s.DB.Port))
+	}
+	if s.DB.MaxOpenConns <= 0 || s.DB.MaxIdleConns < 0 || s.DB.MaxIdleConns > s.DB.MaxOpenConns {
+		problems = append(problems, fmt.Sprintf("the database pool of %d open and %d idle connections is not valid",
+			s.DB.MaxOpenConns, s.DB.MaxIdleConns))
+	}
+	if s.DB.QueryTimeout <= 0 {
+		problems = append(problems, "the database query timeout must be positive")
+	}
+
+	if s.Session.Lifetime <= 0 {
+		problems = append(problems, "the session lifetime must be positive")
+	}
+
+	if s.Mail.From == "" {
+		missing("the address mail comes from", "mail-from", "MAIL_FROM")
+	}
+	if s.Mail.Transport == mailer.TransportSMTP && s.Mail.SMTPHost == "" {
+		missing("the SMTP host", "smtp-host", "SMTP_HOST")
+	}
+
+	if s.Cancellation.FreeDays < 0 {
+		problems = append(problems, fmt.Sprintf("the %d days of free cancellation can't be negative", s.Cancellation.FreeDays))
+	}
+	if s.Cancellation.LateRefundPercent < 0 || s.Cancellation.LateRefundPercent > 100 {
+		problems = append(problems, fmt.Sprintf("the late cancellation refund of %d%% must be between 0 and 100",
+			s.Cancellation.LateRefundPercent))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// DSN is the connection string of the database
+func (d DBSettings) DSN() string {
+	quote := func(v string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+	}
+	return fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+		quote(d.Host), d.Port, quote(d.Name), quote(d.User), quote(d.Password), quote(d.SSLMode))
+}
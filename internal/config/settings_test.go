@@ -0,0 +1,212 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env is an environment for Load
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	s, err := Load(nil, env(map[string]string{"DB_USER": "root"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Addr != ":8081" || s.BaseURL != "http://localhost:8081" {
+		t.Errorf("wrong address %q and base url %q", s.Addr, s.BaseURL)
+	}
+	if s.DB.MaxOpenConns != 10 || s.DB.QueryTimeout != 3*time.Second {
+		t.Errorf("wrong database defaults %+v", s.DB)
+	}
+	if s.Mail.OwnerEmail != s.Mail.From {
+		t.Errorf("expected the owner email to default to %q, got %q", s.Mail.From, s.Mail.OwnerEmail)
+	}
+	if s.InProduction || s.UseCache {
+		t.Error("expected a development server by default")
+	}
+	if s.PaymentGateway != "fake" {
+		t.Errorf("expected the fake payment gateway by default, got %q", s.PaymentGateway)
+	}
+	if s.Cancellation.FreeDays != 7 || s.Cancellation.LateRefundPercent != 50 {
+		t.Errorf("wrong cancellation defaults %+v", s.Cancellation)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeConfig(t, `
+addr: ":9000"
+use_cache: true
+db:
+  host: db.example.com
+  user: file-user
+  password: file-password
+  max_open_conns: 20
+  query_timeout: 5s
+session:
+  lifetime: 2h
+mail:
+  smtp_host: mail.example.com
+cancellation:
+  free_days: 14
+`)
+
+	vars := map[string]string{
+		"CONFIG_FILE":                file,
+		"DB_USER":                    "env-user",
+		"DB_PASSWORD":                "env-password",
+		"SMTP_PORT":                  "2525",
+		"CANCEL_LATE_REFUND_PERCENT": "25",
+	}
+	args := []string{"-db-password", "flag-password", "-session-lifetime", "30m", "migrate", "up"}
+
+	s, err := Load(args, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"file over default", s.Addr, ":9000"},
+		{"file bool", s.UseCache, true},
+		{"file in a section", s.DB.Host, "db.example.com"},
+		{"file int", s.DB.MaxOpenConns, 20},
+		{"file duration", s.DB.QueryTimeout, 5 * time.Second},
+		{"default not in file", s.DB.Port, 5432},
+		{"env over file", s.DB.User, "env-user"},
+		{"env over default", s.Mail.SMTPPort, 2525},
+		{"flag over env", s.DB.Password, "flag-password"},
+		{"flag over file", s.Session.Lifetime, 30 * time.Minute},
+		{"base url from the file's addr", s.BaseURL, "http://localhost:9000"},
+		{"file cancellation", s.Cancellation.FreeDays, 14},
+		{"env cancellation", s.Cancellation.LateRefundPercent, 25},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, e.got)
+		}
+	}
+
+	if len(s.Args) != 2 || s.Args[0] != "migrate" || s.Args[1] != "up" {
+		t.Errorf("expected the arguments after the flags, got %q", s.Args)
+	}
+}
+
+func TestLoad_ConfigFlag(t *testing.T) {
+	fromEnv := writeConfig(t, "db:\n  user: env-file\n")
+	fromFlag := writeConfig(t, "db:\n  user: flag-file\n")
+
+	s, err := Load([]string{"-config", fromFlag}, env(map[string]string{"CONFIG_FILE": fromEnv}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DB.User != "flag-file" {
+		t.Errorf("expected the -config flag to win over $CONFIG_FILE, got user %q", s.DB.User)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		vars     map[string]string
+		file     string
+		expected []string
+	}{
+		{
+			name:     "missing required values",
+			vars:     map[string]string{"DB_HOST": " ", "MAIL_FROM": ""},
+			args:     []string{"-db-host", "", "-production"},
+			expected: []string{"invalid configuration", "database host", "$DB_HOST", "database user", "-db-user", "secret key"},
+		},
+		{
+			name:     "fake payments in production",
+			args:     []string{"-db-user", "root", "-production", "-secret-key", "x"},
+			expected: []string{"fake payment gateway", "$PAYMENT_GATEWAY"},
+		},
+		{
+			name:     "unknown payment gateway",
+			vars:     map[string]string{"DB_USER": "root", "PAYMENT_GATEWAY": "stripe"},
+			expected: []string{`unknown payment gateway "stripe"`},
+		},
+		{
+			name:     "bad cancellation policy",
+			args:     []string{"-db-user", "root", "-cancel-free-days", "-1", "-cancel-late-refund-percent", "150"},
+			expected: []string{"days of free cancellation", "refund of 150%"},
+		},
+		{
+			name:     "bad pool",
+			args:     []string{"-db-user", "root", "-db-max-idle-conns", "50"},
+			expected: []string{"pool of 10 open and 50 idle"},
+		},
+		{
+			name:     "bad env value",
+			vars:     map[string]string{"DB_PORT": "postgres"},
+			expected: []string{"DB_PORT"},
+		},
+		{
+			name:     "unknown flag",
+			args:     []string{"-db-hots", "x"},
+			expected: []string{"db-hots"},
+		},
+		{
+			name:     "unknown key in the file",
+			file:     "db:\n  hots: x\n",
+			expected: []string{"config.yml", "hots"},
+		},
+		{
+			name:     "missing file",
+			vars:     map[string]string{"CONFIG_FILE": "no-such-file.yml"},
+			expected: []string{"no-such-file.yml"},
+		},
+	}
+
+	for _, e := range tests {
+		if e.vars == nil {
+			e.vars = map[string]string{}
+		}
+		if e.file != "" {
+			e.vars["CONFIG_FILE"] = writeConfig(t, e.file)
+		}
+
+		_, err := Load(e.args, env(e.vars))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+		for _, want := range e.expected {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected the error to mention %q, got %q", e.name, want, err)
+			}
+		}
+	}
+}
+
+func TestDBSettings_DSN(t *testing.T) {
+	d := DBSettings{Host: "localhost", Port: 5432, Name: "bookings", User: "root", Password: `it's a \secret`, SSLMode: "disable"}
+
+	expected := `host='localhost' port=5432 dbname='bookings' user='root' password='it\'s a \\secret' sslmode='disable'`
+	if got := d.DSN(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
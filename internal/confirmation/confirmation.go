@@ -0,0 +1,45 @@
+package confirmation
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// Prefix starts every confirmation code
+const Prefix = "BK-"
+
+// codeLength is the number of random characters after the prefix
+const codeLength = 6
+
+// alphabet leaves out 0, 1, I, L and O, which are easy to mix up when a code is read out or typed in
+const alphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// NewCode returns a random confirmation code such as BK-7F3K9Q
+func NewCode() (string, error) {
+	b := make([]byte, codeLength)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return Prefix + string(b), nil
+}
+
+// Normalize tidies up a code the way guests tend to type it: in lower case, with spaces,
+// or without the prefix or its dash
+func Normalize(code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	if code == "" || strings.HasPrefix(code, Prefix) {
+		return code
+	}
+	// a bare code can start with BK too, so it is only the prefix when the whole random part follows
+	short := strings.TrimSuffix(Prefix, "-")
+	if len(code) == len(short)+codeLength && strings.HasPrefix(code, short) {
+		code = strings.TrimPrefix(code, short)
+	}
+	return Prefix + code
+}
@@ -0,0 +1,47 @@
+package confirmation
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewCode(t *testing.T) {
+	format := regexp.MustCompile(`^BK-[2-9A-HJKMNP-Z]{6}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code, err := NewCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("code %q is not in the expected format", code)
+		}
+		if seen[code] {
+			t.Fatalf("code %q was made twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"BK-7F3K9Q", "BK-7F3K9Q"},
+		{" bk-7f3k9q ", "BK-7F3K9Q"},
+		{"7F3K9Q", "BK-7F3K9Q"},
+		{"BK7F3K9Q", "BK-7F3K9Q"},
+		{"BK- 7F3 K9Q", "BK-7F3K9Q"},
+		{"BKM7Q2", "BK-BKM7Q2"},
+		{"bkbkm7q2", "BK-BKM7Q2"},
+		{"", ""},
+	}
+
+	for _, e := range tests {
+		if got := Normalize(e.in); got != e.out {
+			t.Errorf("Normalize(%q) = %q, wanted %q", e.in, got, e.out)
+		}
+	}
+}
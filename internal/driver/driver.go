@@ -16,20 +16,23 @@ type DB struct {
 
 var dbConn = &DB{}
 
-const maxOpenDbConn = 10
-const maxIdleDbConn = 5
-const maxDbLifetime = 5 * time.Minute
+// Pool sizes the db connection pool
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
 
 // ConnectSQL creates db connection pool
-func ConnectSQL(dsn string) (*DB, error) {
+func ConnectSQL(dsn string, pool Pool) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifetime)
+	d.SetMaxOpenConns(pool.MaxOpenConns)
+	d.SetMaxIdleConns(pool.MaxIdleConns)
+	d.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	dbConn.SQL = d
 
@@ -0,0 +1,136 @@
+package emails
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/pricing"
+	htmltemplate "html/template"
+	"path/filepath"
+	"reflect"
+	texttemplate "text/template"
+	"time"
+)
+
+// Names of the emails; each has an HTML template, name.html.tmpl, and a plain text one, name.txt.tmpl
+const (
+	ReservationConfirmation  = "reservation-confirmation"
+	ReservationNotification  = "reservation-notification"
+	ReservationCancelled     = "reservation-cancelled"
+	CancellationNotification = "cancellation-notification"
+	PasswordReset            = "password-reset"
+)
+
+// ReservationData is what the reservation confirmation and notification are made from
+type ReservationData struct {
+	Reservation models.Reservation
+	// ManageURL is the guest's manage your booking link
+	ManageURL string
+}
+
+// CancellationData is what the cancellation emails are made from; Refund is in cents
+type CancellationData struct {
+	Reservation models.Reservation
+	Refund      int
+}
+
+// PasswordResetData is what the password reset email is made from
+type PasswordResetData struct {
+	User models.User
+	Link string
+	// Minutes is how long the link is valid for
+	Minutes int
+}
+
+// dataTypes gives, for every email, the zero value of the data it is made from
+var dataTypes = map[string]interface{}{
+	ReservationConfirmation:  ReservationData{},
+	ReservationNotification:  ReservationData{},
+	ReservationCancelled:     CancellationData{},
+	CancellationNotification: CancellationData{},
+	PasswordReset:            PasswordResetData{},
+}
+
+var functions = map[string]interface{}{
+	"humanDate":   func(t time.Time) string { return t.Format("2006-01-02") },
+	"formatMoney": pricing.FormatMoney,
+}
+
+var pathToTemplates = "./email-templates"
+
+// Template is the HTML and plain text templates of one email
+type Template struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// Cache holds the parsed templates of every email, by name
+type Cache map[string]*Template
+
+// CreateTemplateCache parses the email templates in ./email-templates
+func CreateTemplateCache() (Cache, error) {
+	return CreateTemplateCacheFrom(pathToTemplates)
+}
+
+// CreateTemplateCacheFrom parses the templates of every email in dir, together with the HTML layouts.
+// Every template is tried out on empty data, so a missing template, or one that uses a field
+// its data doesn't have, is an error here rather than when the email is sent.
+func CreateTemplateCacheFrom(dir string) (Cache, error) {
+	myCache := Cache{}
+
+	layouts := filepath.Join(dir, "*.layout.html.tmpl")
+
+	for name, data := range dataTypes {
+		htmlName := name + ".html.tmpl"
+		html, err := htmltemplate.New(htmlName).Funcs(functions).ParseFiles(filepath.Join(dir, htmlName))
+		if err != nil {
+			return myCache, err
+		}
+		html, err = html.ParseGlob(layouts)
+		if err != nil {
+			return myCache, err
+		}
+
+		textName := name + ".txt.tmpl"
+		text, err := texttemplate.New(textName).Funcs(functions).ParseFiles(filepath.Join(dir, textName))
+		if err != nil {
+			return myCache, err
+		}
+
+		t := &Template{html: html, text: text}
+		if _, _, err := t.execute(data); err != nil {
+			return myCache, fmt.Errorf("email %s: %w", name, err)
+		}
+		myCache[name] = t
+	}
+
+	return myCache, nil
+}
+
+// Render makes the HTML and plain text bodies of the email name from data, which must be
+// of the type the email is made from
+func (c Cache) Render(name string, data interface{}) (html, text string, err error) {
+	t, ok := c[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email %s", name)
+	}
+	if want := reflect.TypeOf(dataTypes[name]); reflect.TypeOf(data) != want {
+		return "", "", fmt.Errorf("email %s is made from %s, not %T", name, want, data)
+	}
+	return t.execute(data)
+}
+
+func (t *Template) execute(data interface{}) (string, string, error) {
+	html := new(bytes.Buffer)
+	err := t.html.Execute(html, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	text := new(bytes.Buffer)
+	err = t.text.Execute(text, data)
+	if err != nil {
+		return "", "", err
+	}
+	return html.String(), text.String(), nil
+}
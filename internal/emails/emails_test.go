@@ -0,0 +1,108 @@
+package emails
+
+import (
+	"github.com/jjang65/booking-web-app/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	pathToTemplates = "./../../email-templates"
+}
+
+func TestCreateTemplateCache(t *testing.T) {
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range dataTypes {
+		if tc[name] == nil {
+			t.Errorf("%s is not in the cache", name)
+		}
+	}
+}
+
+func TestCreateTemplateCacheFailsFast(t *testing.T) {
+	original := pathToTemplates
+	defer func() { pathToTemplates = original }()
+
+	var tests = []struct {
+		name   string
+		remove string
+		write  string
+	}{
+		{"missing html template", "password-reset.html.tmpl", ""},
+		{"missing text template", "password-reset.txt.tmpl", ""},
+		{"missing layout", "basic.layout.html.tmpl", ""},
+		{"unknown field", "", "{{.User.Nickname}}"},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		files, _ := filepath.Glob(filepath.Join(original, "*.tmpl"))
+		for _, f := range files {
+			if filepath.Base(f) == e.remove {
+				continue
+			}
+			data, _ := os.ReadFile(f)
+			os.WriteFile(filepath.Join(dir, filepath.Base(f)), data, 0644)
+		}
+		if e.write != "" {
+			os.WriteFile(filepath.Join(dir, "password-reset.txt.tmpl"), []byte(e.write), 0644)
+		}
+
+		pathToTemplates = dir
+		if _, err := CreateTemplateCache(); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestCache_Render(t *testing.T) {
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := models.Reservation{
+		Code:      "BK-7F3K9Q",
+		FirstName: `<script>alert("hi")</script>`,
+		LastName:  "Smith",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Total:     12500,
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	html, text, err := tc.Render(ReservationConfirmation, ReservationData{Reservation: res, ManageURL: "https://example.com/manage?token=a&b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(html, "<script>") || !strings.Contains(html, "&lt;script&gt;") {
+		t.Error("the guest's name was not escaped in the html")
+	}
+	for _, x := range []string{"BK-7F3K9Q", "$125.00", "2050-01-01", `href="https://example.com/manage?token=a&amp;b"`, "<html"} {
+		if !strings.Contains(html, x) {
+			t.Errorf("expected %q in the html", x)
+		}
+	}
+	for _, x := range []string{`<script>alert("hi")</script>`, "BK-7F3K9Q", "$125.00", "https://example.com/manage?token=a&b"} {
+		if !strings.Contains(text, x) {
+			t.Errorf("expected %q in the text, got:\n%s", x, text)
+		}
+	}
+	if strings.Contains(text, "<html") {
+		t.Error("the text has html in it")
+	}
+
+	if _, _, err := tc.Render(ReservationConfirmation, CancellationData{Reservation: res}); err == nil {
+		t.Error("expected an error for the wrong data")
+	}
+	if _, _, err := tc.Render("newsletter", ReservationData{}); err == nil {
+		t.Error("expected an error for an unknown email")
+	}
+}
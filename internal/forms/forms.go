@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"github.com/asaskevich/govalidator"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
+var slugRegexp = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
 // Form creates a custom form struct, embeds an url.Values object
 type Form struct {
 	url.Values
@@ -65,3 +68,10 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsSlug checks for a valid url slug, made of lowercase letters, digits and single dashes
+func (f *Form) IsSlug(field string) {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters, numbers and dashes")
+	}
+}
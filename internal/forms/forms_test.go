@@ -127,3 +127,31 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got valid for invalid email address")
 	}
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	postedValues := url.Values{}
+	form := New(postedValues)
+
+	form.IsSlug("x")
+	if form.Valid() {
+		t.Error("form shows valid slug for non-existent field")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("slug", "generals-quarters")
+	form = New(postedValues)
+	form.IsSlug("slug")
+	if !form.Valid() {
+		t.Error("got an invalid slug when we should not have")
+	}
+
+	for _, x := range []string{"Generals", "generals--quarters", "-generals", "generals's"} {
+		postedValues = url.Values{}
+		postedValues.Add("slug", x)
+		form = New(postedValues)
+		form.IsSlug("slug")
+		if form.Valid() {
+			t.Errorf("got valid for invalid slug %s", x)
+		}
+	}
+}
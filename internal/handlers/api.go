@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jjang65/booking-web-app/internal/confirmation"
+	"github.com/jjang65/booking-web-app/internal/forms"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"github.com/jjang65/booking-web-app/internal/pricing"
+	"github.com/jjang65/booking-web-app/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiDateLayout is the date format used by the api, in requests and responses
+const apiDateLayout = "2006-01-02"
+
+// apiEnvelope wraps every api response; exactly one of Data and Error is set
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiError describes what went wrong with an api request
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// apiRoom is a room as returned by the api
+type apiRoom struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	// NightlyRate is the base rate in cents; seasons and holidays can cost more or less
+	NightlyRate int `json:"nightly_rate"`
+}
+
+// apiAvailability is the result of an availability search
+type apiAvailability struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Rooms     []apiRoom `json:"rooms"`
+}
+
+// apiRoomAvailability is the result of an availability search for one room
+type apiRoomAvailability struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Available bool   `json:"available"`
+	// Total is the price of the stay in cents
+	Total int `json:"total"`
+}
+
+// apiReservation is a reservation as returned by the api
+type apiReservation struct {
+	ConfirmationCode string `json:"confirmation_code"`
+	// the guest's details are left out unless the request has the reservation's manage token
+	FirstName string  `json:"first_name,omitempty"`
+	LastName  string  `json:"last_name,omitempty"`
+	Email     string  `json:"email,omitempty"`
+	Phone     string  `json:"phone,omitempty"`
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date"`
+	Total     int     `json:"total"`
+	Status    string  `json:"status"`
+	Room      apiRoom `json:"room"`
+	// ManageToken is given to whoever made the reservation, to look it up with later
+	ManageToken string `json:"manage_token,omitempty"`
+}
+
+// apiReservationRequest is the body of a request to create a reservation
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	// PaymentToken is the one time card token made by the payment gateway's card form
+	PaymentToken string `json:"payment_token"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:          room.ID,
+		Name:        room.RoomName,
+		Slug:        room.Slug,
+		Description: room.Description,
+		NightlyRate: room.NightlyRate,
+	}
+}
+
+// writeJSON writes data to the response in an api envelope
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	out, _ := json.MarshalIndent(apiEnvelope{Data: data}, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeJSONError writes an api error envelope; fields may be nil
+func writeJSONError(w http.ResponseWriter, status int, message string, fields map[string][]string) {
+	out, _ := json.MarshalIndent(apiEnvelope{Error: &apiError{
+		Status:  status,
+		Message: message,
+		Fields:  fields,
+	}}, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// apiServerError logs err and sends a generic internal server error
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	writeJSONError(w, http.StatusInternalServerError, "Internal server error", nil)
+}
+
+// apiValidationError sends the errors collected in form
+func apiValidationError(w http.ResponseWriter, form *forms.Form) {
+	writeJSONError(w, http.StatusUnprocessableEntity, "The request has invalid fields", form.Errors)
+}
+
+// apiDateRange validates and parses the start_date and end_date fields of form
+func apiDateRange(form *forms.Form) (time.Time, time.Time) {
+	form.Required("start_date", "end_date")
+
+	startDate, err := time.Parse(apiDateLayout, form.Get("start_date"))
+	if err != nil && form.Has("start_date") {
+		form.Errors.Add("start_date", "Use the format YYYY-MM-DD")
+	}
+	endDate, err := time.Parse(apiDateLayout, form.Get("end_date"))
+	if err != nil && form.Has("end_date") {
+		form.Errors.Add("end_date", "Use the format YYYY-MM-DD")
+	}
+
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The end date must be after the start date")
+	}
+	return startDate, endDate
+}
+
+// APINotFound is the api's response for unknown urls
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "Not found", nil)
+}
+
+// APIMethodNotAllowed is the api's response for known urls with the wrong method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+}
+
+// APIRooms lists the rooms that can be booked
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllActiveRooms(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIRoom returns one room
+func (m *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIRoom(room))
+}
+
+// apiRoomFromURL loads the active room in /api/v1/rooms/{id}, and sends an error response if it can't
+func (m *Repository) apiRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Room not found", nil)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (room.ID == 0 || !room.Active)) {
+		writeJSONError(w, http.StatusNotFound, "Room not found", nil)
+		return models.Room{}, false
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return models.Room{}, false
+	}
+	return room, true
+}
+
+// APIAvailability lists the rooms that are free for the dates in the query string
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	startDate, endDate := apiDateRange(form)
+	if !form.Valid() {
+		apiValidationError(w, form)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := apiAvailability{
+		StartDate: startDate.Format(apiDateLayout),
+		EndDate:   endDate.Format(apiDateLayout),
+		Rooms:     []apiRoom{},
+	}
+	for _, room := range rooms {
+		out.Rooms = append(out.Rooms, newAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIRoomAvailability says whether one room is free for the dates in the query string
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.URL.Query())
+	startDate, endDate := apiDateRange(form)
+	if !form.Valid() {
+		apiValidationError(w, form)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, room.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	quote, err := pricing.New(m.DB).Quote(r.Context(), room, startDate, endDate)
+	if errors.Is(err, pricing.ErrNoRate) {
+		writeJSONError(w, http.StatusConflict, "The room has no rate for those dates", nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiRoomAvailability{
+		RoomID:    room.ID,
+		StartDate: startDate.Format(apiDateLayout),
+		EndDate:   endDate.Format(apiDateLayout),
+		Available: available,
+		Total:     quote.Total,
+	})
+}
+
+// APICreateReservation books a room
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "The request body is not valid JSON: "+err.Error(), nil)
+		return
+	}
+
+	// validate with the same rules as the make reservation form
+	form := forms.New(url.Values{
+		"start_date": {req.StartDate},
+		"end_date":   {req.EndDate},
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+		"phone":      {req.Phone},
+	})
+	startDate, endDate := apiDateRange(form)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	today := time.Now().Truncate(24 * time.Hour)
+	if form.Errors.Get("start_date") == "" && startDate.Before(today) {
+		form.Errors.Add("start_date", "The start date can't be in the past")
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), req.RoomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (room.ID == 0 || !room.Active)) {
+		form.Errors.Add("room_id", "Unknown room")
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		apiValidationError(w, form)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, room.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if !available {
+		writeJSONError(w, http.StatusConflict, "The room is not available for those dates", nil)
+		return
+	}
+
+	quote, err := pricing.New(m.DB).Quote(r.Context(), room, startDate, endDate)
+	if errors.Is(err, pricing.ErrNoRate) {
+		writeJSONError(w, http.StatusConflict, "The room has no rate for those dates", nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if quote.Total > 0 && req.PaymentToken == "" {
+		form.Errors.Add("payment_token", "This field cannot be blank")
+		apiValidationError(w, form)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    room.ID,
+		Total:     quote.Total,
+		Status:    models.ReservationPending,
+		Room:      room,
+	}
+
+	reservation, err = m.insertReservation(r.Context(), reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		writeJSONError(w, http.StatusConflict, roomTakenMessage, nil)
+		return
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	reservation, err = m.takePayment(r.Context(), reservation, req.PaymentToken)
+	if errors.Is(err, payments.ErrDeclined) {
+		writeJSONError(w, http.StatusPaymentRequired, paymentErrorMessage(err), nil)
+		return
+	} else if errors.Is(err, errPaymentUnconfirmed) {
+		// the reservation is kept, pending, until the gateway tells us how the payment went
+		m.App.ErrorLog.Println("APICreateReservation: payment:", err)
+		out := m.newAPIReservation(reservation)
+		out.ManageToken = m.manageReservationToken(reservation)
+		writeJSON(w, http.StatusAccepted, out)
+		return
+	} else if err != nil {
+		m.App.ErrorLog.Println("APICreateReservation: payment:", err)
+		writeJSONError(w, http.StatusBadGateway, paymentErrorMessage(err), nil)
+		return
+	}
+
+	m.releaseReservationEmails(r.Context(), reservation)
+
+	out := m.newAPIReservation(reservation)
+	out.ManageToken = m.manageReservationToken(reservation)
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// APIReservation looks up a reservation by its confirmation code. Codes can be guessed, so the guest's
+// details are only given with the reservation's manage token, in ?token=.
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	res, err := m.DB.GetReservationByCode(r.Context(), confirmation.Normalize(exploded[4]))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Reservation not found", nil)
+		return
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := m.newAPIReservation(res)
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		out.FirstName, out.LastName, out.Email, out.Phone = "", "", "", ""
+	} else if code, err := m.manageTokenCode(token); err != nil || code != res.Code {
+		writeJSONError(w, http.StatusForbidden, "The token is invalid or has expired", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (m *Repository) newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ConfirmationCode: res.Code,
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		StartDate:        res.StartDate.Format(apiDateLayout),
+		EndDate:          res.EndDate.Format(apiDateLayout),
+		Total:            res.Total,
+		Status:           res.Status,
+		Room:             newAPIRoom(res.Room),
+	}
+}
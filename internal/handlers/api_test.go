@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	body               string
+	expectedStatusCode int
+	expectedFields     []string
+}{
+	{"rooms", "GET", "/api/v1/rooms", "", http.StatusOK, nil},
+	{"room", "GET", "/api/v1/rooms/1", "", http.StatusOK, nil},
+	{"room with bad id", "GET", "/api/v1/rooms/x", "", http.StatusNotFound, nil},
+	{"room db error", "GET", "/api/v1/rooms/3", "", http.StatusInternalServerError, nil},
+	{"room booked", "GET", "/api/v1/rooms/1/availability?start_date=2050-01-01&end_date=2050-01-03", "", http.StatusOK, nil},
+	{"room free", "GET", "/api/v1/rooms/2/availability?start_date=2050-01-01&end_date=2050-01-03", "", http.StatusOK, nil},
+	{"room availability missing dates", "GET", "/api/v1/rooms/1/availability", "", http.StatusUnprocessableEntity, []string{"start_date", "end_date"}},
+	{"room availability bad date", "GET", "/api/v1/rooms/1/availability?start_date=01/01/2050&end_date=2050-01-03", "", http.StatusUnprocessableEntity, []string{"start_date"}},
+	{"room availability dates reversed", "GET", "/api/v1/rooms/1/availability?start_date=2050-01-03&end_date=2050-01-01", "", http.StatusUnprocessableEntity, []string{"end_date"}},
+	{"all rooms availability", "GET", "/api/v1/availability?start_date=2050-01-01&end_date=2050-01-03", "", http.StatusOK, nil},
+	{"all rooms availability missing dates", "GET", "/api/v1/availability", "", http.StatusUnprocessableEntity, []string{"start_date", "end_date"}},
+	{
+		"book room",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusCreated,
+		nil,
+	},
+	{
+		"book booked room",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-01-01", "end_date": "2050-01-02", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusConflict,
+		nil,
+	},
+	{
+		"book room checking in as the last guest checks out",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-01-02", "end_date": "2050-01-04", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusCreated,
+		nil,
+	},
+	{
+		"book room checking out as the next guest checks in",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2049-12-30", "end_date": "2050-01-01", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusCreated,
+		nil,
+	},
+	{
+		"book with missing fields",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "Jo", "email": "john"}`,
+		http.StatusUnprocessableEntity,
+		[]string{"first_name", "last_name", "email"},
+	},
+	{
+		"book in the past",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2000-02-01", "end_date": "2000-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusUnprocessableEntity,
+		[]string{"start_date"},
+	},
+	{
+		"book unknown room",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 0, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusUnprocessableEntity,
+		[]string{"room_id"},
+	},
+	{
+		"book room just taken",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-12-24", "end_date": "2050-12-26", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusConflict,
+		nil,
+	},
+	{
+		"book with failing insert",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 2, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`,
+		http.StatusInternalServerError,
+		nil,
+	},
+	{
+		"book with declined card",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_decline"}`,
+		http.StatusPaymentRequired,
+		nil,
+	},
+	{
+		"book with declined capture",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_capture_fail"}`,
+		http.StatusPaymentRequired,
+		nil,
+	},
+	{
+		"book with payment timeout",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_timeout"}`,
+		http.StatusAccepted,
+		nil,
+	},
+	{
+		"book without payment",
+		"POST",
+		"/api/v1/reservations",
+		`{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com"}`,
+		http.StatusUnprocessableEntity,
+		[]string{"payment_token"},
+	},
+	{"book with invalid json", "POST", "/api/v1/reservations", `{"room_id": `, http.StatusBadRequest, nil},
+	{"book with unknown field", "POST", "/api/v1/reservations", `{"room": 1}`, http.StatusBadRequest, nil},
+	{"reservation with bad code", "GET", "/api/v1/reservations/not-a-code", "", http.StatusNotFound, nil},
+	{"unknown url", "GET", "/api/v1/nothing-here", "", http.StatusNotFound, nil},
+	{"wrong method", "DELETE", "/api/v1/rooms", "", http.StatusMethodNotAllowed, nil},
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	sentMail.Reset()
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("for %s, expected a json response but got %s", e.name, resp.Header.Get("Content-Type"))
+		}
+
+		var envelope apiEnvelope
+		err = json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("for %s, failed to parse json: %s", e.name, err)
+			continue
+		}
+
+		if resp.StatusCode >= 400 {
+			if envelope.Error == nil || envelope.Error.Status != resp.StatusCode {
+				t.Errorf("for %s, expected an error envelope with status %d", e.name, resp.StatusCode)
+				continue
+			}
+			for _, field := range e.expectedFields {
+				if len(envelope.Error.Fields[field]) == 0 {
+					t.Errorf("for %s, expected an error for field %s", e.name, field)
+				}
+			}
+		} else if envelope.Data == nil || envelope.Error != nil {
+			t.Errorf("for %s, expected a data envelope", e.name)
+		}
+
+		// only bookings that go through send mail
+		if resp.StatusCode == http.StatusCreated {
+			checkSentMail(t, e.name,
+				models.MailData{To: "john@smith.com", From: "bookings@here.com", Subject: "Reservation Confirmation"},
+				models.MailData{To: "owner@here.com", From: "bookings@here.com", Subject: "Reservation Notification"},
+			)
+		} else {
+			checkSentMail(t, e.name)
+		}
+	}
+}
+
+func TestAPI_Availability(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var tests = []struct {
+		url       string
+		available bool
+		total     int
+	}{
+		{"/api/v1/rooms/1/availability?start_date=2050-01-01&end_date=2050-01-03", false, 22500},
+		{"/api/v1/rooms/1/availability?start_date=2050-01-02&end_date=2050-01-03", true, 10000},
+		{"/api/v1/rooms/1/availability?start_date=2049-12-31&end_date=2050-01-01", true, 10000},
+		{"/api/v1/rooms/2/availability?start_date=2050-01-01&end_date=2050-01-03", true, 30000},
+	}
+
+	for _, e := range tests {
+		resp, err := ts.Client().Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var envelope struct {
+			Data apiRoomAvailability `json:"data"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+
+		if envelope.Data.Available != e.available {
+			t.Errorf("for %s, expected available to be %t", e.url, e.available)
+		}
+		if envelope.Data.Total != e.total {
+			t.Errorf("for %s, expected a total of %d but got %d", e.url, e.total, envelope.Data.Total)
+		}
+	}
+}
+
+func TestAPI_ReservationLookup(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	body := `{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_approve"}`
+	resp, err := ts.Client().Post(ts.URL+"/api/v1/reservations", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var created struct {
+		Data apiReservation `json:"data"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if created.Data.ConfirmationCode == "" {
+		t.Fatal("created reservation has no confirmation code")
+	}
+	if resp.StatusCode != http.StatusCreated || created.Data.Status != models.ReservationConfirmed {
+		t.Errorf("expected a paid reservation to be created %s, got %d and %q",
+			models.ReservationConfirmed, resp.StatusCode, created.Data.Status)
+	}
+	if created.Data.Room.Name != "General's Quarters" {
+		t.Errorf("created reservation has the wrong room: %s", created.Data.Room.Name)
+	}
+
+	if created.Data.Email != "john@smith.com" || created.Data.ManageToken == "" {
+		t.Errorf("expected the guest's details and a manage token for whoever booked, got %+v", created.Data)
+	}
+
+	var tests = []struct {
+		name               string
+		code               string
+		token              string
+		expectedStatusCode int
+		// expectedEmail is whether the guest's contact details are given
+		expectedEmail bool
+	}{
+		{"by code", created.Data.ConfirmationCode, "", http.StatusOK, false},
+		{"lower case code", strings.ToLower(created.Data.ConfirmationCode), "", http.StatusOK, false},
+		{"with manage token", created.Data.ConfirmationCode, created.Data.ManageToken, http.StatusOK, true},
+		{"with another reservation's token", "BK-7F3K9Q", created.Data.ManageToken, http.StatusForbidden, false},
+		{"with invalid token", created.Data.ConfirmationCode, "nope", http.StatusForbidden, false},
+		{"unknown code", "12", "", http.StatusNotFound, false},
+		{"database error", "BK-BROKEN", "", http.StatusInternalServerError, false},
+	}
+
+	for _, e := range tests {
+		resp, err = ts.Client().Get(ts.URL + "/api/v1/reservations/" + e.code + "?token=" + url.QueryEscape(e.token))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var found struct {
+			Data apiReservation `json:"data"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&found)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+		if (found.Data.Email != "") != e.expectedEmail {
+			t.Errorf("%s: expected the guest's email to be given %v, got %q", e.name, e.expectedEmail, found.Data.Email)
+		}
+	}
+}
+
+func TestAPI_CreateReservationPaymentUnconfirmed(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	body := `{"room_id": 1, "start_date": "2050-02-01", "end_date": "2050-02-03", "first_name": "John", "last_name": "Smith", "email": "john@smith.com", "payment_token": "tok_timeout"}`
+	resp, err := ts.Client().Post(ts.URL+"/api/v1/reservations", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var created struct {
+		Data apiReservation `json:"data"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted || created.Data.Status != models.ReservationPending {
+		t.Errorf("expected a reservation waiting on its payment to be accepted %s, got %d and %q",
+			models.ReservationPending, resp.StatusCode, created.Data.Status)
+	}
+	// the guest needs the token to see how the payment went
+	if created.Data.ManageToken == "" {
+		t.Errorf("expected a manage token for whoever booked, got %+v", created.Data)
+	}
+}
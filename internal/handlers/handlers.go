@@ -1,18 +1,37 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jjang65/booking-web-app/internal/cancellation"
 	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/confirmation"
 	"github.com/jjang65/booking-web-app/internal/driver"
+	"github.com/jjang65/booking-web-app/internal/emails"
 	"github.com/jjang65/booking-web-app/internal/forms"
 	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/ical"
+	"github.com/jjang65/booking-web-app/internal/icalimport"
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"github.com/jjang65/booking-web-app/internal/pricing"
 	"github.com/jjang65/booking-web-app/internal/render"
 	"github.com/jjang65/booking-web-app/internal/repository"
 	"github.com/jjang65/booking-web-app/internal/repository/dbrepo"
+	"github.com/jjang65/booking-web-app/internal/signer"
+	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -50,7 +69,6 @@ func NewHandlers(r *Repository) {
 
 // Home is the home page handler that can access to everything inside repository
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	m.DB.AllUsers()
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
@@ -73,14 +91,23 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	quote, err := pricing.New(m.DB).Quote(r.Context(), room, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.ErrorLog.Println("Reservation:", err)
+		m.App.Session.Put(r.Context(), "error", "can't get a price for this stay")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	// Put Room info to Session
 	res.Room.RoomName = room.RoomName
+	res.Total = quote.Total
 	log.Println("Reservation::res: ", res)
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -92,6 +119,8 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
+	data["test_payment_tokens"] = m.testPaymentTokens()
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
 		Data:      data,
@@ -132,6 +161,22 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// the price is worked out again here, rather than trusted from the form
+	quote, err := pricing.New(m.DB).Quote(r.Context(), room, startDate, endDate)
+	if err != nil {
+		m.App.ErrorLog.Println("PostReservation:", err)
+		m.App.Session.Put(r.Context(), "error", "can't get a price for this stay")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	reservation := models.Reservation{
 		FirstName: r.Form.Get("first_name"),
 		LastName:  r.Form.Get("last_name"),
@@ -140,6 +185,9 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    roomID,
+		Total:     quote.Total,
+		Status:    models.ReservationPending,
+		Room:      room,
 	}
 
 	form := forms.New(r.PostForm)
@@ -147,10 +195,15 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	if quote.Total > 0 {
+		form.Required("payment_token")
+	}
 
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["quote"] = quote
+		data["test_payment_tokens"] = m.testPaymentTokens()
 		http.Error(w, "my own error message", http.StatusSeeOther)
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 			Form: form,
@@ -159,64 +212,33 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
-	if err != nil {
+	// the reservation and its room restriction go in together, or not at all
+	reservation, err = m.insertReservation(r.Context(), reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", roomTakenMessage)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't insert reservation into db")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 2,
-	}
-
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction1")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	// the room is held while the payment goes through, and released if it doesn't
+	reservation, err = m.takePayment(r.Context(), reservation, r.Form.Get("payment_token"))
+	if errors.Is(err, errPaymentUnconfirmed) {
+		m.App.ErrorLog.Println("PostReservation: payment:", err)
+		m.App.Session.Put(r.Context(), "warning", paymentErrorMessage(err))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.ErrorLog.Println("PostReservation: payment:", err)
+		m.App.Session.Put(r.Context(), "error", paymentErrorMessage(err))
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 		return
 	}
 
-	// Send an email to client
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong>
-		Dear %s:, <br>
-		This is to confirm your reservation from %s to %s.
-	`,
-		reservation.FirstName,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-	)
-	log.Println("reservation.Email: ", reservation.Email)
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
-	}
-	m.App.MailChan <- msg
-
-	// Send an email to owner
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`,
-		reservation.Room.RoomName,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-	)
-	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "me@here.com",
-		Subject: "Reservation Notification",
-		Content: htmlMessage,
-	}
-	m.App.MailChan <- msg
+	m.releaseReservationEmails(r.Context(), reservation)
 
 	log.Println("PostReservation::reservation: ", reservation)
 	m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -224,322 +246,2305 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// roomTakenMessage tells a guest that the room went to someone else while they were booking it
+const roomTakenMessage = "Sorry, this room was just booked by someone else for those dates. Please choose other dates or another room."
+
+// maxCodeAttempts is how many confirmation codes we try before giving up on inserting a reservation
+const maxCodeAttempts = 5
+
+// insertReservation gives a reservation a new confirmation code and inserts it, returning it with its id and code
+func (m *Repository) insertReservation(ctx context.Context, res models.Reservation) (models.Reservation, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := confirmation.NewCode()
+		if err != nil {
+			return res, err
+		}
+		res.Code = code
+
+		mail, err := m.reservationEmails(res)
+		if err != nil {
+			return res, err
+		}
+
+		res.ID, err = m.DB.InsertReservation(ctx, res, mail...)
+		if !errors.Is(err, repository.ErrDuplicateCode) {
+			return res, err
+		}
+	}
+	return res, repository.ErrDuplicateCode
+}
+
+// paymentTimeout is how long we wait for the payment gateway to authorize and capture a payment
+const paymentTimeout = 30 * time.Second
+
+// paymentRecordTimeout is how long we give the database to record how a payment went
+const paymentRecordTimeout = 10 * time.Second
+
+// errPaymentUnconfirmed is returned by takePayment when the gateway didn't answer in time. The
+// payment may have gone through, so the reservation is kept, pending, until the gateway is asked again.
+var errPaymentUnconfirmed = errors.New("payment not confirmed by the gateway")
+
+// errAuthorizationVoided is why a payment failed when the gateway has let go of its authorization
+var errAuthorizationVoided = errors.New("authorization was voided")
+
+// detachedContext has the values of its parent, but not its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// detach returns a context, with its own timeout, for work that must be finished even if the request
+// that started it is cancelled, like recording a payment the card has already been charged for
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detachedContext{parent: ctx}, timeout)
 }
 
-// Availability renders the search availability page
-func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+// paymentReference is what a reservation's payment is authorized with, so the gateway can find it again
+func paymentReference(reservationID int) string {
+	return fmt.Sprintf("Reservation %d", reservationID)
 }
 
-// PostAvailability renders the search availability page
-func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse form!")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+// takePayment authorizes and captures the total of a reservation that has just been inserted,
+// recording each step in the payments table. If the payment is refused, the reservation and its
+// room restriction are deleted, so the room is free again. If the gateway doesn't answer, the
+// reservation is left pending and errPaymentUnconfirmed is returned. The reservation is returned
+// with the status the payment left it in.
+func (m *Repository) takePayment(ctx context.Context, reservation models.Reservation, token string) (models.Reservation, error) {
+	if reservation.Total <= 0 {
+		return reservation, nil
 	}
 
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	payment := models.Payment{
+		ReservationID: reservation.ID,
+		Amount:        reservation.Total,
+		Status:        models.PaymentPending,
+	}
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	var err error
+	payment.ID, err = m.DB.InsertPayment(ctx, payment)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		m.releaseUnpaidReservation(ctx, reservation.ID)
+		return reservation, err
 	}
-	endDate, err := time.Parse(layout, end)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+
+	// once the gateway has been asked, a guest closing the page mustn't leave the payment half done
+	gatewayCtx, cancel := detach(ctx, paymentTimeout)
+	defer cancel()
+
+	payment.AuthorizationID, err = m.App.Payments.Authorize(gatewayCtx, token, payment.Amount,
+		paymentReference(reservation.ID))
+	if err == nil {
+		payment.Status = models.PaymentAuthorized
+		err = m.capturePayment(gatewayCtx, payment)
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+	confirmed, err := m.settlePayment(ctx, payment, err)
+	if confirmed {
+		reservation.Status = models.ReservationConfirmed
 	}
+	return reservation, err
+}
 
-	if len(rooms) == 0 {
-		// no availability
-		m.App.Session.Put(r.Context(), "error", "No availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
-		return
+// capturePayment captures an authorized payment. If the capture is refused, the authorization is
+// voided, so the money isn't held on the card until it expires.
+func (m *Repository) capturePayment(ctx context.Context, payment models.Payment) error {
+	err := m.App.Payments.Capture(ctx, payment.AuthorizationID, payment.Amount)
+	if err != nil && !gatewayTimedOut(err) {
+		if voidErr := m.App.Payments.Void(ctx, payment.AuthorizationID); voidErr != nil {
+			m.App.ErrorLog.Printf("capturePayment: reservation %d: can't void authorization %s: %s",
+				payment.ReservationID, payment.AuthorizationID, voidErr)
+		}
 	}
+	return err
+}
 
-	data := make(map[string]interface{})
-	data["rooms"] = rooms
+// settlePayment records how a payment went, given the error from the gateway, and settles its
+// reservation: a refused payment releases it, and an unanswered one leaves it pending, in which
+// case errPaymentUnconfirmed is returned. The reservation is confirmed if err is nil, and
+// settlePayment says whether that was recorded.
+func (m *Repository) settlePayment(ctx context.Context, payment models.Payment, err error) (bool, error) {
+	switch {
+	case err == nil:
+		payment.Status = models.PaymentCaptured
+		payment.Error = ""
+	case gatewayTimedOut(err):
+		// the gateway may still take the payment, so the status stays at the last step we know of
+		payment.Error = err.Error()
+	case errors.Is(err, payments.ErrDeclined):
+		payment.Status = models.PaymentDeclined
+		payment.Error = err.Error()
+	default:
+		payment.Status = models.PaymentFailed
+		payment.Error = err.Error()
+	}
 
-	res := models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
+	ctx, cancel := detach(ctx, paymentRecordTimeout)
+	defer cancel()
+
+	// the gateway has the final word, so a failure to record it doesn't undo the payment
+	if updateErr := m.DB.UpdatePayment(ctx, payment); updateErr != nil {
+		m.App.ErrorLog.Printf("settlePayment: reservation %d: payment is %s but can't be saved: %s",
+			payment.ReservationID, payment.Status, updateErr)
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	switch {
+	case err == nil:
+		// a paid reservation is confirmed; the payment stands even if that can't be recorded
+		confirmErr := m.DB.UpdateReservationStatus(ctx, payment.ReservationID, models.ReservationConfirmed, 0)
+		if confirmErr != nil {
+			m.App.ErrorLog.Printf("settlePayment: reservation %d is paid but can't be confirmed: %s",
+				payment.ReservationID, confirmErr)
+			return false, nil
+		}
+		return true, nil
+	case gatewayTimedOut(err):
+		return false, fmt.Errorf("%w: %s", errPaymentUnconfirmed, err)
+	default:
+		m.releaseUnpaidReservation(ctx, payment.ReservationID)
+		return false, err
+	}
+}
 
-	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+// gatewayTimedOut reports whether err means the gateway didn't answer, rather than refused
+func gatewayTimedOut(err error) bool {
+	return errors.Is(err, payments.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled)
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+// releaseUnpaidReservation deletes a reservation that wasn't paid for, so the room is free again
+func (m *Repository) releaseUnpaidReservation(ctx context.Context, id int) {
+	ctx, cancel := detach(ctx, paymentRecordTimeout)
+	defer cancel()
+
+	if err := m.DB.DeleteReservation(ctx, id); err != nil {
+		m.App.ErrorLog.Printf("releaseUnpaidReservation: can't release unpaid reservation %d: %s", id, err)
+	}
 }
 
-// AvailabilityJSON handles request for availability and sends JSON response
-func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+// SweepPendingPayments asks the gateway about the payments takePayment gave up waiting for, and
+// settles their reservations: the booking goes through if the money was taken, or the room is
+// released if it wasn't. It also tries again the refunds that failed. It runs in the background.
+func (m *Repository) SweepPendingPayments(ctx context.Context) {
+	// anything newer may still be in the hands of takePayment
+	before := time.Now().Add(-(paymentTimeout + paymentRecordTimeout))
 
-	// Need to parse request body to test; otherwise, impossible to test form
-	err := r.ParseForm()
+	unsettled, err := m.DB.GetUnsettledPayments(ctx, before)
 	if err != nil {
-		// can't parse form, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Internal server error",
+		m.App.ErrorLog.Println("SweepPendingPayments:", err)
+		return
+	}
+
+	for _, payment := range unsettled {
+		err := m.reconcilePayment(ctx, payment)
+		switch {
+		case err == nil:
+			m.App.InfoLog.Printf("SweepPendingPayments: reservation %d is paid", payment.ReservationID)
+			m.releaseReservationEmails(ctx, models.Reservation{ID: payment.ReservationID})
+		case errors.Is(err, errPaymentUnconfirmed):
+			// asked again at the next sweep
+			m.App.ErrorLog.Printf("SweepPendingPayments: reservation %d: %s", payment.ReservationID, err)
+		default:
+			m.App.InfoLog.Printf("SweepPendingPayments: reservation %d released: %s", payment.ReservationID, err)
 		}
+	}
 
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+	owed, err := m.DB.GetPaymentsWithRefundDue(ctx)
+	if err != nil {
+		m.App.ErrorLog.Println("SweepPendingPayments:", err)
 		return
 	}
 
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
+	for _, payment := range owed {
+		refundCtx, cancel := context.WithTimeout(ctx, paymentTimeout)
+		err := m.refundPayment(refundCtx, payment)
+		cancel()
+		if err != nil {
+			m.App.ErrorLog.Printf("SweepPendingPayments: payment %d: refund of %d failed again: %s",
+				payment.ID, payment.RefundDue, err)
+		}
+	}
+}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+// reconcilePayment finds out from the gateway how a payment went, captures it if it was only
+// authorized, and settles its reservation
+func (m *Repository) reconcilePayment(ctx context.Context, payment models.Payment) error {
+	gatewayCtx, cancel := context.WithTimeout(ctx, paymentTimeout)
+	defer cancel()
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	auth, err := m.App.Payments.Lookup(gatewayCtx, paymentReference(payment.ReservationID))
 	if err != nil {
-		// can't parse form, so return appropriate json
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error connecting to db",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "     ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+		_, err = m.settlePayment(ctx, payment, err)
+		return err
 	}
 
-	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
-		RoomID:    strconv.Itoa(roomID),
+	payment.AuthorizationID = auth.ID
+	payment.Status = models.PaymentAuthorized
+	switch {
+	case auth.Captured > 0:
+		err = nil
+	case auth.Voided:
+		err = errAuthorizationVoided
+	default:
+		err = m.capturePayment(gatewayCtx, payment)
 	}
+	_, err = m.settlePayment(ctx, payment, err)
+	return err
+}
 
-	// Remove the error check, since we handle all aspects of json above
-	out, _ := json.MarshalIndent(resp, "", "     ")
+// paymentErrorMessage explains to the guest why their payment didn't go through
+func paymentErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, payments.ErrDeclined):
+		return "Your card was declined, so the room has not been booked. Please try another card."
+	case errors.Is(err, errPaymentUnconfirmed):
+		return "We couldn't confirm your payment with our payment processor yet. The room is held for you " +
+			"while we check, and we'll email you your confirmation as soon as the payment has gone through."
+	default:
+		return "We couldn't take your payment, so the room has not been booked. Please try again."
+	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+// testPaymentTokens returns the card tokens to offer on the reservation form when payments are simulated
+func (m *Repository) testPaymentTokens() map[string]string {
+	if _, ok := m.App.Payments.(*payments.FakeGateway); ok {
+		return payments.FakeTokens
+	}
+	return nil
 }
 
-// Contact renders the contact page
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+// newMail makes the email name from data, addressed to to, with the envelope from the config
+func (m *Repository) newMail(to, subject, name string, data interface{}) (models.MailData, error) {
+	html, text, err := m.App.EmailTemplates.Render(name, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:              to,
+		From:            m.App.MailFrom,
+		Subject:         subject,
+		Content:         html,
+		Text:            text,
+		ReplyTo:         m.App.MailReplyTo,
+		ReturnPath:      m.App.MailReturnPath,
+		ListUnsubscribe: m.App.MailListUnsubscribe,
+	}, nil
 }
 
-// ReservationSummary displays the reservation summary page
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	// Get reservation type info from Session
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		//log.Println("cannot get item from session")
-		m.App.ErrorLog.Println("can't get error from session")
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+// reservationEmails returns the confirmation to the guest and the notification to the owner
+func (m *Repository) reservationEmails(reservation models.Reservation) ([]models.MailData, error) {
+	data := emails.ReservationData{
+		Reservation: reservation,
+		ManageURL:   m.manageReservationURL(reservation),
 	}
-	// Remove reservation from session
-	m.App.Session.Remove(r.Context(), "reservation")
 
-	room, err := m.DB.GetRoomByID(reservation.RoomID)
-	log.Println("room: ", room)
+	guest, err := m.newMail(reservation.Email, "Reservation Confirmation", emails.ReservationConfirmation, data)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return nil, err
 	}
+	guest.Attachments = []models.MailAttachment{m.reservationInvite(reservation, "REQUEST")}
 
-	reservation.Room.RoomName = room.RoomName
+	owner, err := m.newMail(m.App.OwnerEmail, "Reservation Notification", emails.ReservationNotification, data)
+	if err != nil {
+		return nil, err
+	}
+	return []models.MailData{guest, owner}, nil
+}
 
-	data := make(map[string]interface{})
-	data["reservation"] = reservation
-	log.Println("data: ", data)
+// reservationInvite makes the calendar invite for a guest's stay. method is REQUEST for a new booking,
+// or CANCEL to take it out of the guest's calendar again; both have the same uid, made from the
+// confirmation code, so calendars match them up.
+func (m *Repository) reservationInvite(res models.Reservation, method string) models.MailAttachment {
+	manageURL := m.manageReservationURL(res)
 
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	// the organizer is a bare address, even if the mail comes from "Name <address>"
+	organizer := m.App.MailFrom
+	if a, err := mail.ParseAddress(organizer); err == nil {
+		organizer = a.Address
+	}
 
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-	})
-}
+	e := ical.Event{
+		UID:         fmt.Sprintf("booking-%s@%s", res.Code, m.icalDomain()),
+		Stamp:       time.Now(),
+		Start:       res.StartDate,
+		End:         res.EndDate,
+		AllDay:      true,
+		Summary:     "Your stay in " + res.Room.RoomName,
+		Description: fmt.Sprintf("Confirmation code: %s\nManage your booking: %s", res.Code, manageURL),
+		Location:    m.App.PropertyAddress,
+		URL:         manageURL,
+		Status:      "CONFIRMED",
+		Organizer:   organizer,
+		Attendees:   []string{res.Email},
+	}
+	if method == "CANCEL" {
+		e.Status = "CANCELLED"
+		e.Sequence = 1
+	}
 
-// ChooseRoom displays available rooms
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	// changed to this, so we can test it more easily
-	// split the URL up by /, and grab the 3rd element
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "missing url parameter")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+	cal := &ical.Calendar{
+		ProdID: "-//Bookings//Reservation//EN",
+		Method: method,
+		Events: []ical.Event{e},
 	}
 
-	// Get reservation info from session
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		helpers.ServerError(w, err)
-		return
+	return models.MailAttachment{
+		Filename:    res.Code + ".ics",
+		ContentType: ical.ContentType + "; method=" + method,
+		Data:        cal.Bytes(),
 	}
+}
 
-	// Update reservation's roomID and put session back
-	res.RoomID = roomID
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+// releaseReservationEmails lets the mail about a reservation go out, once the booking has gone through
+func (m *Repository) releaseReservationEmails(ctx context.Context, reservation models.Reservation) {
+	err := m.DB.ReleaseReservationMail(ctx, reservation.ID)
+	if err != nil {
+		// the booking stands; the held mail shows up in the outbox
+		m.App.ErrorLog.Printf("releaseReservationEmails: reservation %d: %s", reservation.ID, err)
+	}
 }
 
-// BookRoom takes url parameters, builds a session variables, takes user to make reservation page
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	// id, s, e from url params
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
+// manageReservationTTL is how long after departure a manage your booking link keeps working
+const manageReservationTTL = 30 * 24 * time.Hour
 
-	var res models.Reservation
+// manageReservationURL returns the signed link guests use to see and cancel their reservation
+func (m *Repository) manageReservationURL(res models.Reservation) string {
+	token := m.manageReservationToken(res)
+	return fmt.Sprintf("%s/reservations/manage?token=%s", m.App.BaseURL, url.QueryEscape(token))
+}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+// manageReservationToken returns the signed token that gives access to a reservation
+func (m *Repository) manageReservationToken(res models.Reservation) string {
+	return signer.New(m.App.SecretKey).Sign("manage|"+res.Code, res.EndDate.Add(manageReservationTTL))
+}
 
-	room, err := m.DB.GetRoomByID(roomID)
-	log.Println("room: ", room)
+// reservationFromManageToken returns the reservation a manage your booking link was made for
+func (m *Repository) reservationFromManageToken(ctx context.Context, token string) (models.Reservation, error) {
+	code, err := m.manageTokenCode(token)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return models.Reservation{}, err
 	}
+	return m.DB.GetReservationByCode(ctx, code)
+}
 
-	res.Room.RoomName = room.RoomName
-	res.RoomID = roomID
-	res.StartDate = startDate
-	res.EndDate = endDate
-
-	m.App.Session.Put(r.Context(), "reservation", res)
+// manageTokenCode returns the confirmation code of the reservation a manage token was made for
+func (m *Repository) manageTokenCode(token string) (string, error) {
+	data, err := signer.New(m.App.SecretKey).Verify(token)
+	if err != nil {
+		return "", err
+	}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	if !strings.HasPrefix(data, "manage|") {
+		return "", signer.ErrInvalidToken
+	}
+	return strings.TrimPrefix(data, "manage|"), nil
 }
 
-// ShowLogin shows the login page
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
-}
+// cancellationTerms returns what a guest gets back if they cancel res now
+func (m *Repository) cancellationTerms(ctx context.Context, res models.Reservation) (cancellation.Terms, []models.Payment, error) {
+	paid, err := m.DB.GetPaymentsForReservation(ctx, res.ID)
+	if err != nil {
+		return cancellation.Terms{}, nil, err
+	}
 
-// PostShowLogin handles logging the user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	// Call RenewToken() to prevent session fixation attack; Must call when login or logout
-	_ = m.App.Session.RenewToken(r.Context())
+	amount := 0
+	for _, p := range paid {
+		if p.Status == models.PaymentCaptured {
+			amount += p.Amount - p.Refunded - p.RefundDue
+		}
+	}
+	return m.App.Cancellation.Terms(res, amount, time.Now()), paid, nil
+}
 
-	// Parse form
-	err := r.ParseForm()
+// ShowManageReservation shows guests their reservation, and lets them cancel it
+func (m *Repository) ShowManageReservation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	res, err := m.reservationFromManageToken(r.Context(), token)
 	if err != nil {
-		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	email := r.Form.Get("email")
+	terms, _, err := m.cancellationTerms(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["terms"] = terms
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// PostCancelReservation cancels a reservation for a guest, refunding them as the cancellation policy allows
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+	res, err := m.reservationFromManageToken(r.Context(), token)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	manageURL := "/reservations/manage?token=" + url.QueryEscape(token)
+
+	terms, paid, err := m.cancellationTerms(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !terms.Allowed {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	mail, err := m.cancellationEmails(res, terms.Refund)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the reservation is cancelled first, with its row locked, so cancelling it twice at once can't
+	// refund it twice
+	err = m.DB.UpdateReservationStatus(r.Context(), res.ID, models.ReservationCancelled, 0, mail...)
+	if errors.Is(err, lifecycle.ErrInvalidTransition) {
+		// the reservation changed since we looked at it
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+
+	err = m.refundPayments(r.Context(), paid, terms.Refund)
+	if err != nil {
+		m.App.ErrorLog.Printf("PostCancelReservation: refund for reservation %d: %s", res.ID, err)
+		m.App.Session.Put(r.Context(), "warning", "We couldn't refund your payment straight away. "+
+			"We'll keep trying, and will get in touch if it doesn't go through.")
+	}
+
+	http.Redirect(w, r, manageURL, http.StatusSeeOther)
+}
+
+// refundPayments gives back amount cents from the captured payments of a reservation. What each
+// payment is to give back is recorded before the gateway is asked, so a refund that fails is tried
+// again by SweepPendingPayments.
+func (m *Repository) refundPayments(ctx context.Context, paid []models.Payment, amount int) error {
+	ctx, cancel := detach(ctx, paymentTimeout)
+	defer cancel()
+
+	var failed error
+	for _, p := range paid {
+		if amount <= 0 {
+			break
+		}
+		if p.Status != models.PaymentCaptured {
+			continue
+		}
+
+		refund := p.Amount - p.Refunded - p.RefundDue
+		if refund > amount {
+			refund = amount
+		}
+		if refund <= 0 {
+			continue
+		}
+		amount -= refund
+
+		p.RefundDue += refund
+		if err := m.DB.UpdatePayment(ctx, p); err != nil {
+			// the guest is still refunded; only trying again needs the record
+			m.App.ErrorLog.Printf("refundPayments: payment %d: can't record a refund of %d: %s", p.ID, refund, err)
+		}
+
+		if err := m.refundPayment(ctx, p); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// refundPayment asks the gateway to refund what is due on a payment, and records how it went
+func (m *Repository) refundPayment(ctx context.Context, p models.Payment) error {
+	err := m.App.Payments.Refund(ctx, p.AuthorizationID, p.RefundDue)
+	if err != nil {
+		p.Error = err.Error()
+		if updateErr := m.DB.UpdatePayment(ctx, p); updateErr != nil {
+			m.App.ErrorLog.Printf("refundPayment: payment %d: can't record a failed refund: %s", p.ID, updateErr)
+		}
+		return err
+	}
+
+	refund := p.RefundDue
+	p.Refunded += refund
+	p.RefundDue = 0
+	p.Error = ""
+	if p.Refunded == p.Amount {
+		p.Status = models.PaymentRefunded
+	}
+	if err := m.DB.UpdatePayment(ctx, p); err != nil {
+		// left as it is, the refund would be made again, so this needs to be put right by hand
+		m.App.ErrorLog.Printf("refundPayment: payment %d was refunded %d but can't be saved: %s", p.ID, refund, err)
+	}
+	return nil
+}
+
+// cancellationEmails returns the messages telling the guest and the owner that a reservation was cancelled
+func (m *Repository) cancellationEmails(res models.Reservation, refund int) ([]models.MailData, error) {
+	data := emails.CancellationData{
+		Reservation: res,
+		Refund:      refund,
+	}
+
+	guest, err := m.newMail(res.Email, "Reservation Cancelled", emails.ReservationCancelled, data)
+	if err != nil {
+		return nil, err
+	}
+	// takes the stay out of the guest's calendar
+	guest.Attachments = []models.MailAttachment{m.reservationInvite(res, "CANCEL")}
+
+	owner, err := m.newMail(m.App.OwnerEmail, "Cancellation Notification", emails.CancellationNotification, data)
+	if err != nil {
+		return nil, err
+	}
+	return []models.MailData{guest, owner}, nil
+}
+
+// Rooms renders the list of rooms
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllActiveRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the room page for the room with the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	// /rooms/{slug}
+	exploded := strings.Split(r.URL.Path, "/")
+	slug := exploded[2]
+
+	room, err := m.DB.GetRoomBySlug(r.Context(), slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// retired rooms are no longer shown to guests
+	if !room.Active {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// roomCalendarURL returns the secret url of a room's calendar feed, or "" if it doesn't have one
+func (m *Repository) roomCalendarURL(room models.Room) string {
+	if room.ID == 0 || room.ICalToken == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/rooms/%d/calendar.ics?token=%s", m.App.BaseURL, room.ID, room.ICalToken)
+}
+
+// RoomCalendar serves a room's reservations and owner blocks as an iCalendar feed, for channel partners and phones
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	// /rooms/{id}/calendar.ics
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// a wrong token looks just like a missing room
+	token := r.URL.Query().Get("token")
+	if room.ICalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.ICalToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, now.AddDate(-1, 0, 0), now.AddDate(2, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	_, _ = m.roomICalendar(room, restrictions).WriteTo(w)
+}
+
+// icalDomain is the domain part of the uids in our calendar feeds
+func (m *Repository) icalDomain() string {
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "bookings"
+}
+
+// roomICalendar turns a room's restrictions into a calendar; guest details are left out,
+// since the feed is shared with channel partners
+func (m *Repository) roomICalendar(room models.Room, restrictions []models.RoomRestriction) *ical.Calendar {
+	domain := m.icalDomain()
+
+	cal := &ical.Calendar{
+		ProdID: "-//Bookings//Room Calendar//EN",
+		Name:   room.RoomName,
+	}
+
+	for _, rr := range restrictions {
+		// uids are built from ids that never change, so calendars update events instead of duplicating them
+		e := ical.Event{
+			Stamp:  rr.UpdatedAt,
+			Start:  rr.StartDate,
+			End:    rr.EndDate,
+			AllDay: true,
+			Status: "CONFIRMED",
+		}
+		switch rr.RestrictionID {
+		case models.RestrictionReservation:
+			e.UID = fmt.Sprintf("reservation-%d@%s", rr.ReservationID, domain)
+			e.Summary = "Reserved"
+			e.Categories = []string{"RESERVATION"}
+		case models.RestrictionOwnerBlock:
+			e.UID = fmt.Sprintf("block-%d@%s", rr.ID, domain)
+			e.Summary = "Blocked by owner"
+			e.Categories = []string{"OWNER-BLOCK"}
+		default:
+			e.UID = fmt.Sprintf("restriction-%d@%s", rr.ID, domain)
+			e.Summary = "Unavailable"
+		}
+		cal.Events = append(cal.Events, e)
+	}
+	return cal
+}
+
+// Availability renders the search availability page
+func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostAvailability renders the search availability page
+func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	start := r.Form.Get("start")
+	end := r.Form.Get("end")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if len(rooms) == 0 {
+		// no availability
+		m.App.Session.Put(r.Context(), "error", "No availability")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	res := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+type jsonResponse struct {
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// AvailabilityJSON handles request for availability and sends JSON response
+func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+
+	// Need to parse request body to test; otherwise, impossible to test form
+	err := r.ParseForm()
+	if err != nil {
+		// can't parse form, so return appropriate json
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Internal server error",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	sd := r.Form.Get("start")
+	ed := r.Form.Get("end")
+
+	layout := "2006-01-02"
+	startDate, err1 := time.Parse(layout, sd)
+	endDate, err2 := time.Parse(layout, ed)
+	roomID, err3 := strconv.Atoi(r.Form.Get("room_id"))
+	if err1 != nil || err2 != nil || err3 != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Invalid dates or room",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
+	if err != nil {
+		// can't parse form, so return appropriate json
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Error connecting to db",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:        available,
+		Message:   "",
+		StartDate: sd,
+		EndDate:   ed,
+		RoomID:    strconv.Itoa(roomID),
+	}
+
+	// Remove the error check, since we handle all aspects of json above
+	out, _ := json.MarshalIndent(resp, "", "     ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Contact renders the contact page
+func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+}
+
+// ReservationSummary displays the reservation summary page
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	// Get reservation type info from Session
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		//log.Println("cannot get item from session")
+		m.App.ErrorLog.Println("can't get error from session")
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	// Remove reservation from session
+	m.App.Session.Remove(r.Context(), "reservation")
+
+	room, err := m.DB.GetRoomByID(r.Context(), reservation.RoomID)
+	log.Println("room: ", room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation.Room.RoomName = room.RoomName
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	log.Println("data: ", data)
+
+	sd := reservation.StartDate.Format("2006-01-02")
+	ed := reservation.EndDate.Format("2006-01-02")
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+
+	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// ChooseRoom displays available rooms
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	// changed to this, so we can test it more easily
+	// split the URL up by /, and grab the 3rd element
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// Get reservation info from session
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// Update reservation's roomID and put session back
+	res.RoomID = roomID
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// BookRoom takes url parameters, builds a session variables, takes user to make reservation page
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	// id, s, e from url params
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	sd := r.URL.Query().Get("s")
+	ed := r.URL.Query().Get("e")
+
+	var res models.Reservation
+
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, sd)
+	endDate, _ := time.Parse(layout, ed)
+
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	log.Println("room: ", room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !room.Active {
+		m.App.Session.Put(r.Context(), "error", "This room is no longer available")
+		http.Redirect(w, r, "/rooms", http.StatusSeeOther)
+		return
+	}
+
+	res.Room.RoomName = room.RoomName
+	res.RoomID = roomID
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// ShowLogin shows the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	// Call RenewToken() to prevent session fixation attack; Must call when login or logout
+	_ = m.App.Session.RenewToken(r.Context())
+
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
+	if err != nil {
+		log.Println("PostShowLogin:err: ", err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs out user
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Destroy(r.Context())
+	m.App.Session.RenewToken(r.Context())
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// passwordFingerprint identifies a user's current password hash, so reset tokens stop working once it changes
+func passwordFingerprint(u models.User) string {
+	sum := sha256.Sum256([]byte(u.Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// passwordResetToken creates a signed password reset token for a user
+func (m *Repository) passwordResetToken(u models.User, expires time.Time) string {
+	data := fmt.Sprintf("pwreset|%d|%s", u.ID, passwordFingerprint(u))
+	return signer.New(m.App.SecretKey).Sign(data, expires)
+}
+
+// userFromResetToken returns the user a password reset token was issued to,
+// as long as the token is valid, unexpired and the password hasn't changed since
+func (m *Repository) userFromResetToken(ctx context.Context, token string) (models.User, error) {
+	data, err := signer.New(m.App.SecretKey).Verify(token)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	parts := strings.Split(data, "|")
+	if len(parts) != 3 || parts[0] != "pwreset" {
+		return models.User{}, signer.ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return models.User{}, signer.ErrInvalidToken
+	}
+
+	u, err := m.DB.GetUserByID(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if !u.Active || parts[2] != passwordFingerprint(u) {
+		return models.User{}, signer.ErrInvalidToken
+	}
+	return u, nil
+}
+
+// ShowForgotPassword shows the forgot password page
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a password reset link to the user
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(r.Context(), r.Form.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// don't tell the visitor whether the address belongs to an account
+	if err == nil && u.Active {
+		link := fmt.Sprintf("%s/user/reset-password?token=%s",
+			m.App.BaseURL, m.passwordResetToken(u, time.Now().Add(passwordResetTTL)))
+
+		msg, err := m.newMail(u.Email, "Password Reset", emails.PasswordReset, emails.PasswordResetData{
+			User:    u,
+			Link:    link,
+			Minutes: int(passwordResetTTL.Minutes()),
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertMail(r.Context(), msg)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If that email belongs to an account, a reset link is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ShowResetPassword shows the reset password page for a valid reset token
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := m.userFromResetToken(r.Context(), token); err != nil {
+		m.App.Session.Put(r.Context(), "error", "This reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostResetPassword stores a new password for the user a reset token was issued to
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.Form.Get("token")
+	u, err := m.userFromResetToken(r.Context(), token)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", 8)
+	if r.Form.Get("password") != r.Form.Get("password_confirm") {
+		form.Errors.Add("password_confirm", "Passwords don't match")
+	}
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Form.Get("password")), 12)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateUserPassword(r.Context(), u.ID, string(hash))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, please log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// AdminAllReservations shows all reservations in admin, optionally only those with one status
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !lifecycle.Valid(status) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if status != "" {
+		var filtered []models.Reservation
+		for _, x := range reservations {
+			if x.Status == status {
+				filtered = append(filtered, x)
+			}
+		}
+		reservations = filtered
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["statuses"] = lifecycle.Statuses()
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminSearchReservations finds a reservation by its confirmation code, and opens it
+func (m *Repository) AdminSearchReservations(w http.ResponseWriter, r *http.Request) {
+	code := confirmation.Normalize(r.URL.Query().Get("code"))
+	if code == "" {
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.DB.GetReservationByCode(r.Context(), code)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("No reservation has the code %s", code))
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d", res.ID), http.StatusSeeOther)
+}
+
+// AdminNewReservations shows the reservations waiting to be confirmed in admin
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminReservationsCalendar displays the reservation calendar
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume that there is no month/year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, err := strconv.Atoi(r.URL.Query().Get("y"))
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		month, err := strconv.Atoi(r.URL.Query().Get("m"))
+		if err != nil || month < 1 || month > 12 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.UTC)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	next := firstOfMonth.AddDate(0, 1, 0)
+	last := firstOfMonth.AddDate(0, -1, 0)
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+	stringMap["this_month"] = firstOfMonth.Format("01")
+	stringMap["this_month_year"] = firstOfMonth.Format("2006")
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	data := make(map[string]interface{})
+	data["now"] = firstOfMonth
+
+	rooms, err := m.DB.AllActiveRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["rooms"] = rooms
+
+	for _, x := range rooms {
+		// create maps keyed by day of the month; a value of 0 means nothing on that night
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		externalMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
+		}
+
+		// get all the restrictions for the current room
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			// end date is the departure day, so the room is only taken up to the night before
+			for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+				key := d.Format("2006-01-2")
+				if _, ok := reservationMap[key]; !ok {
+					// outside of this month
+					continue
+				}
+
+				switch y.RestrictionID {
+				case models.RestrictionReservation:
+					reservationMap[key] = y.ReservationID
+				case models.RestrictionOwnerBlock:
+					blockMap[key] = y.ID
+				case models.RestrictionExternal:
+					externalMap[key] = y.ID
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminPostReservationsCalendar handles post of the reservations calendar, adding and removing owner blocks
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, err := strconv.Atoi(r.Form.Get("y"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	month, err := strconv.Atoi(r.Form.Get("m"))
+	if err != nil || month < 1 || month > 12 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// process removed blocks: the page posts back every blocked night it showed, with its block id, so a
+	// night whose box is no longer checked is taken out of its block, and the rest of the block stays
+	removed := make(map[int][]time.Time)
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "rendered_block_") {
+			continue
+		}
+		// rendered_block_{roomID}_{date}
+		exploded := strings.Split(name, "_")
+		if len(exploded) != 4 || form.Has(fmt.Sprintf("remove_block_%s_%s", exploded[2], exploded[3])) {
+			continue
+		}
+		blockID, err := strconv.Atoi(r.PostForm.Get(name))
+		if err != nil {
+			continue
+		}
+		night, err := time.Parse("2006-01-2", exploded[3])
+		if err != nil {
+			continue
+		}
+		removed[blockID] = append(removed[blockID], night)
+	}
+
+	for blockID, nights := range removed {
+		err := m.DB.DeleteBlockNights(r.Context(), blockID, nights)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	// now handle new blocks; a night that was booked while the calendar was open isn't blocked
+	var taken []string
+	for name := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			// add_block_{roomID}_{date}
+			exploded := strings.Split(name, "_")
+			if len(exploded) != 4 {
+				continue
+			}
+			roomID, err := strconv.Atoi(exploded[2])
+			if err != nil {
+				continue
+			}
+			t, err := time.Parse("2006-01-2", exploded[3])
+			if err != nil {
+				continue
+			}
+
+			err = m.DB.InsertBlockForRoom(r.Context(), roomID, t)
+			if errors.Is(err, repository.ErrRoomUnavailable) {
+				taken = append(taken, t.Format("2006-01-02"))
+			} else if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+	}
+
+	if len(taken) > 0 {
+		sort.Strings(taken)
+		m.App.Session.Put(r.Context(), "error",
+			"The room is already booked for some of these nights, so they weren't blocked: "+strings.Join(taken, ", "))
+	}
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%02d", year, month), http.StatusSeeOther)
+}
+
+// AdminShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	// split the URL up by /, so we can test it more easily
+	// /admin/reservations/{src}/{id}
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	src := exploded[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["back"] = adminReservationsURL(src, r)
+	stringMap["year"] = r.FormValue("y")
+	stringMap["month"] = r.FormValue("m")
+
+	// Get reservation from the database
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	paid, err := m.DB.GetPaymentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changes, err := m.DB.GetReservationStatusChanges(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["payments"] = paid
+	data["status_changes"] = changes
+	data["next_statuses"] = lifecycle.Next(res.Status)
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation updates the reservation from the admin tool
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// /admin/reservations/{src}/{id}
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	src := exploded[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["back"] = adminReservationsURL(src, r)
+	stringMap["year"] = r.FormValue("y")
+	stringMap["month"] = r.FormValue("m")
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if !startDate.IsZero() && !endDate.IsZero() && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	// moving the stay changes its price
+	if form.Valid() && (!startDate.Equal(res.StartDate) || !endDate.Equal(res.EndDate)) {
+		room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		quote, err := pricing.New(m.DB).Quote(r.Context(), room, startDate, endDate)
+		if errors.Is(err, pricing.ErrNoRate) {
+			form.Errors.Add("start_date", "The room has no rate for some of these dates")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else {
+			res.Total = quote.Total
+		}
+	}
+
+	if form.Valid() {
+		res.StartDate = startDate
+		res.EndDate = endDate
+
+		err = m.DB.UpdateReservation(r.Context(), res)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			form.Errors.Add("start_date", "The room is already booked for some of these dates")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else {
+			m.App.Session.Put(r.Context(), "flash", "Changes saved")
+			http.Redirect(w, r, adminReservationsURL(src, r), http.StatusSeeOther)
+			return
+		}
+	}
+
+	stringMap["start_date"] = r.Form.Get("start_date")
+	stringMap["end_date"] = r.Form.Get("end_date")
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminDeleteReservation deletes a reservation
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	// /admin/reservations/{src}/{id}/delete
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	src := exploded[3]
+
+	err = m.DB.DeleteReservation(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	http.Redirect(w, r, adminReservationsURL(src, r), http.StatusSeeOther)
+}
+
+// AdminUpdateReservationStatus moves one or many reservations to a new status
+func (m *Repository) AdminUpdateReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// /admin/reservations/{src}/status
+	exploded := strings.Split(r.URL.Path, "/")
+	src := exploded[3]
+
+	status := r.Form.Get("status")
+	if !lifecycle.Valid(status) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var ids []int
+	for _, x := range r.Form["id"] {
+		id, err := strconv.Atoi(x)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		m.App.Session.Put(r.Context(), "warning", "No reservations selected")
+		http.Redirect(w, r, adminReservationsURL(src, r), http.StatusSeeOther)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	// reservations that can't make the change are skipped, and the rest still change
+	changed := 0
+	var skipped []string
+	for _, id := range ids {
+		err = m.DB.UpdateReservationStatus(r.Context(), id, status, userID)
+		if errors.Is(err, lifecycle.ErrInvalidTransition) {
+			skipped = append(skipped, strconv.Itoa(id))
+			continue
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		changed++
+	}
+
+	label := strings.ToLower(lifecycle.Label(status))
+	if len(ids) == 1 && changed == 1 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", label))
+	} else if changed > 0 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d reservations marked as %s", changed, label))
+	}
+	if len(skipped) > 0 {
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("Can't mark reservation %s as %s from its current status", strings.Join(skipped, ", "), label))
+	}
+	http.Redirect(w, r, adminReservationsURL(src, r), http.StatusSeeOther)
+}
+
+// adminReservationsURL returns the admin page a reservation was opened from, so we can send the user back there
+func adminReservationsURL(src string, r *http.Request) string {
+	if src == "cal" {
+		return fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", r.FormValue("y"), r.FormValue("m"))
+	}
+	return fmt.Sprintf("/admin/reservations-%s", src)
+}
+
+// AdminRooms shows all rooms in admin
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowRoom shows the form to create or edit a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	// /admin/rooms/{id}, where id is "new" for a new room
+	exploded := strings.Split(r.URL.Path, "/")
+
+	room := models.Room{Active: true}
+	if exploded[3] != "new" {
+		id, err := strconv.Atoi(exploded[3])
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		room, err = m.DB.GetRoomByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderAdminRoom(w, r, room, forms.New(nil))
+}
+
+// renderAdminRoom renders the room form, along with the room's rates
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	var rates []models.RoomRate
+	if room.ID != 0 {
+		var err error
+		rates, err = m.DB.GetRoomRates(r.Context(), room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rates"] = rates
+	data["weekday_names"] = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+	stringMap := make(map[string]string)
+	stringMap["ical_url"] = m.roomCalendarURL(room)
+	if form.Has("nightly_rate") {
+		stringMap["nightly_rate"] = form.Get("nightly_rate")
+	} else {
+		stringMap["nightly_rate"] = strings.TrimPrefix(pricing.FormatMoney(room.NightlyRate), "$")
+	}
+
+	render.Template(w, r, "admin-rooms-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostShowRoom creates or updates a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// /admin/rooms/{id}, where id is "new" for a new room
+	exploded := strings.Split(r.URL.Path, "/")
+
+	var room models.Room
+	if exploded[3] != "new" {
+		id, err := strconv.Atoi(exploded[3])
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		room, err = m.DB.GetRoomByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = helpers.Slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+	room.Description = r.Form.Get("description")
+	room.Image = strings.TrimSpace(r.Form.Get("image"))
+	room.Active = r.Form.Get("active") == "1"
+	room.ICalImportURL = strings.TrimSpace(r.Form.Get("ical_import_url"))
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "nightly_rate")
+	form.IsSlug("slug")
+	if form.Has("nightly_rate") {
+		room.NightlyRate, err = pricing.ParseMoney(form.Get("nightly_rate"))
+		if err != nil || room.NightlyRate == 0 {
+			form.Errors.Add("nightly_rate", "Enter an amount, like 120.00")
+		}
+	}
+	if room.ICalImportURL != "" {
+		u, err := url.Parse(room.ICalImportURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			form.Errors.Add("ical_import_url", "Enter an http or https url")
+		}
+	}
+
+	if form.Valid() {
+		// slugs end up in urls, so they have to be unique
+		existing, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+		if err == nil && existing.ID != room.ID {
+			form.Errors.Add("slug", "This slug is already used by another room")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	if room.ID == 0 {
+		room.ICalToken, err = helpers.RandomToken(16)
+		if err == nil {
+			_, err = m.DB.InsertRoom(r.Context(), room)
+		}
+	} else {
+		err = m.DB.UpdateRoom(r.Context(), room)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomRate adds a rate to a room, for a season, some days of the week or a holiday
+func (m *Repository) AdminPostRoomRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// /admin/rooms/{id}/rates
+	exploded := strings.Split(r.URL.Path, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", roomID)
+
+	rate := models.RoomRate{
+		RoomID: roomID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+	}
+
+	layout := "2006-01-02"
+	problem := ""
+	if rate.Name == "" {
+		problem = "Give the rate a name"
+	}
+	rate.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		problem = "Enter the first night of the rate"
+	}
+	rate.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		problem = "Enter the last night of the rate"
+	} else if rate.EndDate.Before(rate.StartDate) {
+		problem = "The last night can't be before the first"
+	}
+	rate.NightlyRate, err = pricing.ParseMoney(r.Form.Get("nightly_rate"))
+	if err != nil || rate.NightlyRate == 0 {
+		problem = "Enter a nightly rate, like 120.00"
+	}
+	for _, d := range r.Form["weekdays"] {
+		day, err := strconv.Atoi(d)
+		if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+			problem = "Invalid day of the week"
+			break
+		}
+		rate.Weekdays |= pricing.WeekdayMask(time.Weekday(day))
+	}
+
+	if problem != "" {
+		m.App.Session.Put(r.Context(), "error", problem)
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertRoomRate(r.Context(), rate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate added")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomRate deletes one of a room's rates
+func (m *Repository) AdminDeleteRoomRate(w http.ResponseWriter, r *http.Request) {
+	// /admin/rooms/{id}/rates/{rateID}/delete
+	exploded := strings.Split(r.URL.Path, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	rateID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRoomRate(r.Context(), roomID, rateID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+}
+
+// AdminRotateRoomICalToken gives a room's calendar feed a new secret token, so the old feed url stops working
+func (m *Repository) AdminRotateRoomICalToken(w http.ResponseWriter, r *http.Request) {
+	// /admin/rooms/{id}/ical-token
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	token, err := helpers.RandomToken(16)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateRoomICalToken(r.Context(), id, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar feed link changed; update it wherever the old one was used")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminImportRoomCalendar imports bookings made on other platforms into a room, either from
+// an uploaded calendar file or from the room's import url, and shows what changed
+func (m *Repository) AdminImportRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	// /admin/rooms/{id}/ical-import
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	importer := icalimport.New(m.DB, m.icalDomain())
+
+	var report icalimport.Report
+	file, _, err := r.FormFile("ics_file")
+	if err == nil {
+		defer file.Close()
+		report, err = importer.Import(r.Context(), room, file)
+	} else {
+		report, err = importer.Fetch(r.Context(), room)
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("AdminImportRoomCalendar:", err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Calendar import failed: %s", err))
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["report"] = report
+
+	render.Template(w, r, "admin-rooms-import.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// SyncRoomCalendars imports the calendars of all active rooms that have an import url; it runs in the background
+func (m *Repository) SyncRoomCalendars(ctx context.Context) {
+	rooms, err := m.DB.AllActiveRooms(ctx)
+	if err != nil {
+		m.App.ErrorLog.Println("SyncRoomCalendars:", err)
+		return
+	}
+
+	importer := icalimport.New(m.DB, m.icalDomain())
+	for _, room := range rooms {
+		if room.ICalImportURL == "" {
+			continue
+		}
+
+		report, err := importer.Fetch(ctx, room)
+		if err != nil {
+			m.App.ErrorLog.Printf("SyncRoomCalendars: room %d: %s", room.ID, err)
+			continue
+		}
+
+		m.App.InfoLog.Printf("Calendar sync for %s: %d added, %d moved, %d removed",
+			room.RoomName, report.Added, report.Updated, report.Removed)
+		for _, c := range report.Conflicts {
+			m.App.ErrorLog.Printf("Calendar sync for %s: %s from %s to %s overlaps reservation %d",
+				room.RoomName, c.UID, c.StartDate.Format("2006-01-02"), c.EndDate.Format("2006-01-02"), c.ReservationID)
+		}
+	}
+}
+
+// AdminUsers shows all staff users in admin
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowUser shows the form to create or edit a staff user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	// /admin/users/{id}, where id is "new" for a new user
+	exploded := strings.Split(r.URL.Path, "/")
+
+	u := models.User{AccessLevel: models.AccessLevelViewer, Active: true}
+	if exploded[3] != "new" {
+		id, err := strconv.Atoi(exploded[3])
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		u, err = m.DB.GetUserByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = u
+
+	render.Template(w, r, "admin-users-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostShowUser creates or updates a staff user
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// /admin/users/{id}, where id is "new" for a new user
+	exploded := strings.Split(r.URL.Path, "/")
+
+	var u models.User
+	if exploded[3] != "new" {
+		id, err := strconv.Atoi(exploded[3])
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+
+		u, err = m.DB.GetUserByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	u.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	u.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	u.Email = strings.TrimSpace(r.Form.Get("email"))
+	u.Active = r.Form.Get("active") == "1"
+	accessLevel, _ := strconv.Atoi(r.Form.Get("access_level"))
+	u.AccessLevel = accessLevel
 	password := r.Form.Get("password")
 
 	form := forms.New(r.PostForm)
-	form.Required("email", "password")
+	form.Required("first_name", "last_name", "email")
 	form.IsEmail("email")
+	if u.ID == 0 {
+		form.Required("password")
+	}
+	if password != "" {
+		form.MinLength("password", 8)
+	}
+	if accessLevel < models.AccessLevelViewer || accessLevel > models.AccessLevelOwner {
+		form.Errors.Add("access_level", "Choose an access level")
+	}
+
+	// owners can't lock themselves out
+	if authUser, ok := helpers.AuthUser(r); ok && authUser.ID == u.ID {
+		if !u.Active {
+			form.Errors.Add("active", "You can't deactivate your own account")
+		}
+		if accessLevel != authUser.AccessLevel {
+			form.Errors.Add("access_level", "You can't change your own access level")
+		}
+	}
+
+	if form.Valid() {
+		// email addresses are used to log in, so they have to be unique
+		existing, err := m.DB.GetUserByEmail(r.Context(), u.Email)
+		if err == nil && existing.ID != u.ID {
+			form.Errors.Add("email", "This email address is already used by another user")
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
 	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		data := make(map[string]interface{})
+		data["user"] = u
+		render.Template(w, r, "admin-users-show.page.tmpl", &models.TemplateData{
+			Data: data,
 			Form: form,
 		})
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	var hashedPassword string
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		hashedPassword = string(hash)
+	}
+
+	if u.ID == 0 {
+		u.Password = hashedPassword
+		_, err = m.DB.InsertUser(r.Context(), u)
+	} else {
+		err = m.DB.UpdateUser(r.Context(), u)
+		if err == nil && hashedPassword != "" {
+			err = m.DB.UpdateUserPassword(r.Context(), u.ID, hashedPassword)
+		}
+	}
 	if err != nil {
-		log.Println("PostShowLogin:err: ", err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "User saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
-// Logout logs out user
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	m.App.Session.Destroy(r.Context())
-	m.App.Session.RenewToken(r.Context())
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+// mailStatuses are the statuses a message in the outbox can have, in the order it goes through them
+var mailStatuses = []string{
+	models.MailHeld,
+	models.MailPending,
+	models.MailSending,
+	models.MailSent,
+	models.MailDead,
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
-}
+// AdminMail shows the messages in the outbox, optionally only those with one status
+func (m *Repository) AdminMail(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	valid := status == ""
+	for _, x := range mailStatuses {
+		valid = valid || x == status
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 
-// AdminAllReservations shows all reservations in admin
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	messages, err := m.DB.AllMail(r.Context(), status)
 	if err != nil {
 		helpers.ServerError(w, err)
+		return
 	}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	data["messages"] = messages
+	data["statuses"] = mailStatuses
+	render.Template(w, r, "admin-mail.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
 	})
 }
 
-// AdminNewReservations shows all new reservations in admin
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+// AdminShowMail shows a message in the outbox, and why the attempts to send it failed
+func (m *Repository) AdminShowMail(w http.ResponseWriter, r *http.Request) {
+	// /admin/mail/{id}
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	msg, err := m.DB.GetMailByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
 		helpers.ServerError(w, err)
+		return
 	}
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+	data["message"] = msg
+	render.Template(w, r, "admin-mail-show.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
 
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{})
+// AdminResendMail puts a message the workers gave up on back in the outbox
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	// /admin/mail/{id}/resend
+	exploded := strings.Split(r.URL.Path, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.ResendMail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "Only messages that could not be sent can be resent")
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "The message will be sent again")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/mail/%d", id), http.StatusSeeOther)
 }
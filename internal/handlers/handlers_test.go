@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/jjang65/booking-web-app/internal/helpers"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 type postData struct {
@@ -48,6 +54,120 @@ var theTests = []struct {
 		"GET",
 		http.StatusOK,
 	},
+	{
+		"rooms",
+		"/rooms",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"room by slug",
+		"/rooms/generals-quarters",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"non-existent room",
+		"/rooms/no-such-room",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"retired room",
+		"/rooms/old-cabin",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"room db error",
+		"/rooms/broken",
+		"GET",
+		http.StatusInternalServerError,
+	},
+	{
+		"admin rooms",
+		"/admin/rooms",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin new room",
+		"/admin/rooms/new",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin edit room",
+		"/admin/rooms/1",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin non-existent room",
+		"/admin/rooms/2000",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"room calendar feed",
+		"/rooms/1/calendar.ics?token=generals-token",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"room calendar feed with another room's token",
+		"/rooms/1/calendar.ics?token=majors-token",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"room calendar feed without token",
+		"/rooms/1/calendar.ics",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"room calendar feed with bad id",
+		"/rooms/x/calendar.ics?token=generals-token",
+		"GET",
+		http.StatusNotFound,
+	},
+	{
+		"room calendar feed db error",
+		"/rooms/3/calendar.ics?token=generals-token",
+		"GET",
+		http.StatusInternalServerError,
+	},
+	{
+		"forgot password",
+		"/user/forgot-password",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin users",
+		"/admin/users",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin new user",
+		"/admin/users/new",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin edit user",
+		"/admin/users/2",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"admin non-existent user",
+		"/admin/users/1000",
+		"GET",
+		http.StatusNotFound,
+	},
 	{
 		"sa",
 		"/search-availability",
@@ -60,6 +180,24 @@ var theTests = []struct {
 		"GET",
 		http.StatusOK,
 	},
+	{
+		"calendar",
+		"/admin/reservations-calendar",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"calendar with params",
+		"/admin/reservations-calendar?y=2050&m=01",
+		"GET",
+		http.StatusOK,
+	},
+	{
+		"show res",
+		"/admin/reservations/all/1",
+		"GET",
+		http.StatusOK,
+	},
 	//{
 	//	"post-search-avail",
 	//	"/search-availability",
@@ -114,8 +252,10 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
-		Room:   models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
 	}
 	req, _ := http.NewRequest("GET", "/make-reservation", nil)
 	// Get Context containing Session
@@ -132,6 +272,22 @@ func TestRepository_Reservation(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+	// a Saturday at the weekend rate and a Sunday at the base rate
+	if !strings.Contains(rr.Body.String(), "$225.00") {
+		t.Error("reservation handler did not show the total price")
+	}
+
+	//	test case where the stay can't be priced
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("reservation handler returned wrong response code for a stay without dates: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
 
 	//	test case where reservation is not in session (reset everything)
 	// In this case, http status code should be 307
@@ -162,6 +318,8 @@ func TestRepository_Reservation(t *testing.T) {
 }
 
 func TestRepository_PostReservation(t *testing.T) {
+	sentMail.Reset()
+
 	// Test valid case
 	reqBody := "start_date=2050-01-01"
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
@@ -169,6 +327,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -191,6 +350,37 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	// the night of 2050-01-01 is a Saturday, at the weekend rate
+	res, ok := session.Get(ctx, "reservation").(models.Reservation)
+	if !ok || res.Total != 12500 {
+		t.Errorf("reservation handler stored the wrong total: %+v", res)
+	}
+	if res.Status != models.ReservationConfirmed {
+		t.Errorf("expected the paid reservation to be stored as %s, got %q", models.ReservationConfirmed, res.Status)
+	}
+
+	// once paid for, the guest gets a confirmation and the owner a notification
+	sent := checkSentMail(t, "valid reservation",
+		models.MailData{To: "j@smith.com", From: "bookings@here.com", Subject: "Reservation Confirmation"},
+		models.MailData{To: "owner@here.com", From: "bookings@here.com", Subject: "Reservation Notification"},
+	)
+	if len(sent) == 2 {
+		for _, x := range []string{res.Code, "$125.00", "/reservations/manage?token="} {
+			if !strings.Contains(sent[0].Content, x) {
+				t.Errorf("expected %q in the confirmation, got %s", x, sent[0].Content)
+			}
+			if !strings.Contains(sent[0].Text, x) {
+				t.Errorf("expected %q in the plain text confirmation, got %s", x, sent[0].Text)
+			}
+		}
+		checkInvite(t, "valid reservation", sent[0], "REQUEST", res.Code)
+		if sent[0].ReplyTo != "frontdesk@here.com" {
+			t.Errorf("expected the confirmation to have the configured reply-to, got %q", sent[0].ReplyTo)
+		}
+		if len(sent[1].Attachments) != 0 {
+			t.Errorf("expected no attachments on the owner's notification, got %d", len(sent[1].Attachments))
+		}
+	}
 
 	//	Test for missing post body
 	req, _ = http.NewRequest("POST", "/make-reservation", nil)
@@ -221,6 +411,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -251,6 +442,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -281,6 +473,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=invalid")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -311,6 +504,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -334,14 +528,15 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("reservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	// Test for failure to insert restriction into database
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
+	// Test for the room being booked by someone else in the meantime
+	reqBody = "start_date=2050-12-24"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-12-26")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1000") // should fail expectedly
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
 	// Get Context containing Session
@@ -359,10 +554,16 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	// http status code should be StatusTemporaryRedirect (307)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting resrvation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code for a room just taken: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler should send the guest back to search for a room just taken, got %v", loc)
 	}
+	if msg := session.GetString(ctx, "error"); msg != roomTakenMessage {
+		t.Errorf("expected the guest to be told the room was just taken, got %q", msg)
+	}
+	checkSentMail(t, "room just taken")
 
 	// Test for failure to insert reservation into database
 	reqBody = "start_date=2050-01-01"
@@ -371,6 +572,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=j@smith.com")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=j@123123123")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "payment_token=tok_approve")
 	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=2") // Should fail as expected
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
@@ -393,6 +595,64 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation handler failed when trying to fail inserting resrvation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// Test payments that don't go through
+	var paymentTests = []struct {
+		name             string
+		token            string
+		expectedLocation string
+		expectedMessage  string
+		// held is whether the reservation is kept, with its mail, until the payment is confirmed
+		held bool
+	}{
+		{"declined card", "tok_decline", "/make-reservation", "error", false},
+		{"declined capture", "tok_capture_fail", "/make-reservation", "error", false},
+		{"payment timeout", "tok_timeout", "/", "warning", true},
+		{"capture timeout", "tok_capture_timeout", "/", "warning", true},
+		{"missing card", "", "", "", false},
+	}
+
+	for _, e := range paymentTests {
+		postedData := url.Values{
+			"start_date":    {"2050-01-01"},
+			"end_date":      {"2050-01-02"},
+			"first_name":    {"John"},
+			"last_name":     {"Smith"},
+			"email":         {"j@smith.com"},
+			"room_id":       {"1"},
+			"payment_token": {e.token},
+		}
+		req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: PostReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if e.token != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+			if session.GetString(ctx, e.expectedMessage) == "" {
+				t.Errorf("%s: expected a %s in the session", e.name, e.expectedMessage)
+			}
+		}
+
+		// nothing is booked yet, so nothing is sent
+		checkSentMail(t, e.name)
+
+		// a reservation whose payment is still being checked keeps its mail for when it goes through
+		_ = Repo.DB.ReleaseReservationMail(context.Background(), 1)
+		if sent := sentMail.Messages(); (len(sent) > 0) != e.held {
+			t.Errorf("%s: expected the reservation to be held %v, but %d emails were held for it", e.name, e.held, len(sent))
+		}
+		sentMail.Reset()
+	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
@@ -433,6 +693,1098 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	}
 }
 
+func TestRepository_AdminShowReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid reservation", "/admin/reservations/all/1", http.StatusOK, "Admin User"},
+		{"non-existent reservation", "/admin/reservations/all/2000", http.StatusNotFound, ""},
+		{"failure to get the reservation", "/admin/reservations/all/1000", http.StatusInternalServerError, ""},
+		{"invalid id", "/admin/reservations/new/abc", http.StatusNotFound, ""},
+		{"from calendar", "/admin/reservations/cal/1?y=2050&m=01", http.StatusOK, ""},
+		{"checked in", "/admin/reservations/all/60", http.StatusOK, "Checked in"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminShowReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{
+			"valid update from all",
+			"/admin/reservations/all/1",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"phone":      {"555-555-5555"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusSeeOther,
+			"/admin/reservations-all",
+		},
+		{
+			"valid update from new",
+			"/admin/reservations/new/1",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusSeeOther,
+			"/admin/reservations-new",
+		},
+		{
+			"invalid form",
+			"/admin/reservations/all/1",
+			url.Values{
+				"first_name": {"J"},
+				"last_name":  {"Smith"},
+				"email":      {"invalid"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusOK,
+			"",
+		},
+		{
+			"departure before arrival",
+			"/admin/reservations/all/1",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-01-03"},
+				"end_date":   {"2050-01-01"},
+			},
+			http.StatusOK,
+			"",
+		},
+		{
+			"room already booked",
+			"/admin/reservations/all/1",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-12-24"},
+				"end_date":   {"2050-12-26"},
+			},
+			http.StatusOK,
+			"",
+		},
+		{
+			"non-existent reservation",
+			"/admin/reservations/all/2000",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusNotFound,
+			"",
+		},
+		{
+			"failure to get the reservation",
+			"/admin/reservations/all/1000",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"failure to update",
+			"/admin/reservations/all/100",
+			url.Values{
+				"first_name": {"John"},
+				"last_name":  {"Smith"},
+				"email":      {"j@smith.com"},
+				"start_date": {"2050-01-01"},
+				"end_date":   {"2050-01-03"},
+			},
+			http.StatusInternalServerError,
+			"",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostShowReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminDeleteReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"delete from all", "/admin/reservations/all/1/delete", http.StatusSeeOther, "/admin/reservations-all"},
+		{"delete from new", "/admin/reservations/new/1/delete", http.StatusSeeOther, "/admin/reservations-new"},
+		{"delete from calendar", "/admin/reservations/cal/1/delete?y=2050&m=01", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=01"},
+		{"failure to delete", "/admin/reservations/all/100/delete", http.StatusInternalServerError, ""},
+		{"non-existent reservation", "/admin/reservations/all/2000/delete", http.StatusNotFound, ""},
+		{"invalid id", "/admin/reservations/all/abc/delete", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminDeleteReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminUpdateReservationStatus(t *testing.T) {
+	// in the test repo, reservations are confirmed, except for 50, which is cancelled
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedWarning    bool
+	}{
+		{
+			"check in one from new",
+			"/admin/reservations/new/status",
+			url.Values{"id": {"1"}, "status": {"checked_in"}},
+			http.StatusSeeOther,
+			"/admin/reservations-new",
+			false,
+		},
+		{
+			"check in many from new",
+			"/admin/reservations/new/status",
+			url.Values{"id": {"1", "2", "3"}, "status": {"checked_in"}},
+			http.StatusSeeOther,
+			"/admin/reservations-new",
+			false,
+		},
+		{
+			"cancel from all",
+			"/admin/reservations/all/status",
+			url.Values{"id": {"1"}, "status": {"cancelled"}},
+			http.StatusSeeOther,
+			"/admin/reservations-all",
+			false,
+		},
+		{
+			"change not allowed",
+			"/admin/reservations/all/status",
+			url.Values{"id": {"1", "50"}, "status": {"checked_in"}},
+			http.StatusSeeOther,
+			"/admin/reservations-all",
+			true,
+		},
+		{
+			"nothing selected",
+			"/admin/reservations/new/status",
+			url.Values{"status": {"confirmed"}},
+			http.StatusSeeOther,
+			"/admin/reservations-new",
+			true,
+		},
+		{
+			"invalid status",
+			"/admin/reservations/new/status",
+			url.Values{"id": {"1"}, "status": {"processed"}},
+			http.StatusBadRequest,
+			"",
+			false,
+		},
+		{
+			"invalid id",
+			"/admin/reservations/new/status",
+			url.Values{"id": {"abc"}, "status": {"confirmed"}},
+			http.StatusBadRequest,
+			"",
+			false,
+		},
+		{
+			"failure to update",
+			"/admin/reservations/new/status",
+			url.Values{"id": {"1", "100"}, "status": {"checked_in"}},
+			http.StatusInternalServerError,
+			"",
+			false,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminUpdateReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminUpdateReservationStatus returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if hasWarning := session.Exists(ctx, "warning"); hasWarning != e.expectedWarning {
+			t.Errorf("%s: expected warning %t, got %t", e.name, e.expectedWarning, hasWarning)
+		}
+	}
+}
+
+func TestRepository_AdminAllReservations(t *testing.T) {
+	var tests = []struct {
+		name               string
+		status             string
+		expectedStatusCode int
+		expectedIDs        []int
+	}{
+		{"all", "", http.StatusOK, []int{1, 2, 50}},
+		{"pending", "pending", http.StatusOK, []int{2}},
+		{"cancelled", "cancelled", http.StatusOK, []int{50}},
+		{"unknown status", "processed", http.StatusBadRequest, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-all?status="+e.status, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminAllReservations returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		for _, id := range []int{1, 2, 50} {
+			link := fmt.Sprintf(`href="/admin/reservations/all/%d"`, id)
+			expected := false
+			for _, x := range e.expectedIDs {
+				expected = expected || x == id
+			}
+			if strings.Contains(rr.Body.String(), link) != expected {
+				t.Errorf("%s: reservation %d listed is %t, wanted %t", e.name, id, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"current month", "/admin/reservations-calendar", http.StatusOK},
+		{"given month", "/admin/reservations-calendar?y=2050&m=02", http.StatusOK},
+		{"invalid year", "/admin/reservations-calendar?y=abc&m=02", http.StatusBadRequest},
+		{"invalid month", "/admin/reservations-calendar?y=2050&m=13", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminReservationsCalendar returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		// the test repo puts a block on the fourth night of the month, which is posted back with its id
+		month := time.Now()
+		if e.url != "/admin/reservations-calendar" {
+			month = time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)
+		}
+		rendered := fmt.Sprintf(`name="rendered_block_1_%s-4"`, month.Format("2006-01"))
+		if !strings.Contains(rr.Body.String(), rendered) {
+			t.Errorf("%s: expected the block on the fourth to be posted back", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		// expectedError is in the error shown after the redirect
+		expectedError string
+	}{
+		{
+			"add and keep blocks",
+			url.Values{
+				"y":                          {"2050"},
+				"m":                          {"01"},
+				"add_block_1_2050-01-10":     {"1"},
+				"rendered_block_1_2050-01-4": {"2"},
+				"remove_block_1_2050-01-4":   {"2"},
+			},
+			http.StatusSeeOther,
+			"",
+		},
+		{
+			"remove block",
+			url.Values{
+				"y":                          {"2050"},
+				"m":                          {"01"},
+				"rendered_block_1_2050-01-4": {"2"},
+			},
+			http.StatusSeeOther,
+			"",
+		},
+		{
+			"failure to remove block",
+			url.Values{
+				"y":                          {"2050"},
+				"m":                          {"01"},
+				"rendered_block_1_2050-01-4": {"100"},
+			},
+			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"block not shown is left alone",
+			url.Values{
+				"y":                        {"2050"},
+				"m":                        {"01"},
+				"remove_block_1_2050-01-4": {"100"},
+			},
+			http.StatusSeeOther,
+			"",
+		},
+		{
+			"block a booked night",
+			url.Values{
+				"y":                      {"2050"},
+				"m":                      {"01"},
+				"add_block_1_2050-01-1":  {"1"},
+				"add_block_1_2050-01-10": {"1"},
+			},
+			http.StatusSeeOther,
+			"already booked for some of these nights, so they weren't blocked: 2050-01-01",
+		},
+		{
+			"failure to add block",
+			url.Values{
+				"y":                        {"2050"},
+				"m":                        {"01"},
+				"add_block_100_2050-01-10": {"1"},
+			},
+			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"invalid year",
+			url.Values{"y": {"abc"}, "m": {"01"}},
+			http.StatusBadRequest,
+			"",
+		},
+		{
+			"invalid month",
+			url.Values{"y": {"2050"}, "m": {"13"}},
+			http.StatusBadRequest,
+			"",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostReservationsCalendar returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if msg := session.GetString(ctx, "error"); !strings.Contains(msg, e.expectedError) || (e.expectedError == "") != (msg == "") {
+			t.Errorf("%s: expected the error %q, got %q", e.name, e.expectedError, msg)
+		}
+
+		if rr.Code == http.StatusSeeOther {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != "/admin/reservations-calendar?y=2050&m=01" {
+				t.Errorf("%s: wrong redirect location %s", e.name, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostShowRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+	}{
+		{
+			"new room",
+			"/admin/rooms/new",
+			url.Values{"room_name": {"Cabin"}, "description": {"A cabin"}, "active": {"1"}, "nightly_rate": {"95"}},
+			http.StatusSeeOther,
+		},
+		{
+			"update room",
+			"/admin/rooms/1",
+			url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "active": {"1"}, "nightly_rate": {"$1,100.50"}},
+			http.StatusSeeOther,
+		},
+		{
+			"retire room",
+			"/admin/rooms/2",
+			url.Values{"room_name": {"Major's Suite"}, "slug": {"majors-suite"}, "nightly_rate": {"150.00"}},
+			http.StatusSeeOther,
+		},
+		{
+			"invalid nightly rate",
+			"/admin/rooms/2",
+			url.Values{"room_name": {"Major's Suite"}, "slug": {"majors-suite"}, "nightly_rate": {"cheap"}},
+			http.StatusOK,
+		},
+		{
+			"free room",
+			"/admin/rooms/2",
+			url.Values{"room_name": {"Major's Suite"}, "slug": {"majors-suite"}, "nightly_rate": {"0.00"}},
+			http.StatusOK,
+		},
+		{
+			"missing name",
+			"/admin/rooms/new",
+			url.Values{"room_name": {""}},
+			http.StatusOK,
+		},
+		{
+			"invalid slug",
+			"/admin/rooms/new",
+			url.Values{"room_name": {"Cabin"}, "slug": {"Not A Slug"}},
+			http.StatusOK,
+		},
+		{
+			"room with calendar import url",
+			"/admin/rooms/1",
+			url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "active": {"1"},
+				"nightly_rate": {"100"}, "ical_import_url": {"https://partner.example.com/calendar.ics"}},
+			http.StatusSeeOther,
+		},
+		{
+			"invalid calendar import url",
+			"/admin/rooms/1",
+			url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "active": {"1"},
+				"ical_import_url": {"ftp://partner.example.com/calendar.ics"}},
+			http.StatusOK,
+		},
+		{
+			"local file as calendar import url",
+			"/admin/rooms/1",
+			url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "active": {"1"},
+				"nightly_rate": {"100"}, "ical_import_url": {"file:///etc/passwd"}},
+			http.StatusOK,
+		},
+		{
+			"slug used by another room",
+			"/admin/rooms/2",
+			url.Values{"room_name": {"Major's Suite"}, "slug": {"generals-quarters"}},
+			http.StatusOK,
+		},
+		{
+			"non-existent room",
+			"/admin/rooms/2000",
+			url.Values{"room_name": {"Cabin"}},
+			http.StatusNotFound,
+		},
+		{
+			"failure to get the room",
+			"/admin/rooms/1000",
+			url.Values{"room_name": {"Cabin"}},
+			http.StatusInternalServerError,
+		},
+		{
+			"invalid id",
+			"/admin/rooms/abc",
+			url.Values{"room_name": {"Cabin"}},
+			http.StatusNotFound,
+		},
+		{
+			"failure to insert",
+			"/admin/rooms/new",
+			url.Values{"room_name": {"fail"}, "nightly_rate": {"100"}},
+			http.StatusInternalServerError,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostShowRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostShowRoom returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowUser(t *testing.T) {
+	owner := models.User{ID: 1, AccessLevel: models.AccessLevelOwner, Active: true}
+
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+	}{
+		{
+			"new user",
+			"/admin/users/new",
+			url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@here.com"},
+				"access_level": {"2"}, "password": {"password123"}, "active": {"1"}},
+			http.StatusSeeOther,
+		},
+		{
+			"new user without password",
+			"/admin/users/new",
+			url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@here.com"},
+				"access_level": {"2"}, "active": {"1"}},
+			http.StatusOK,
+		},
+		{
+			"new user with short password",
+			"/admin/users/new",
+			url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@here.com"},
+				"access_level": {"2"}, "password": {"short"}, "active": {"1"}},
+			http.StatusOK,
+		},
+		{
+			"new user with email already taken",
+			"/admin/users/new",
+			url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"desk@here.com"},
+				"access_level": {"2"}, "password": {"password123"}, "active": {"1"}},
+			http.StatusOK,
+		},
+		{
+			"invalid access level",
+			"/admin/users/new",
+			url.Values{"first_name": {"Jane"}, "last_name": {"Doe"}, "email": {"jane@here.com"},
+				"access_level": {"9"}, "password": {"password123"}, "active": {"1"}},
+			http.StatusOK,
+		},
+		{
+			"edit user keeping password",
+			"/admin/users/2",
+			url.Values{"first_name": {"Fran"}, "last_name": {"Desk"}, "email": {"desk@here.com"},
+				"access_level": {"1"}, "active": {"1"}},
+			http.StatusSeeOther,
+		},
+		{
+			"edit user with new password",
+			"/admin/users/2",
+			url.Values{"first_name": {"Fran"}, "last_name": {"Desk"}, "email": {"desk@here.com"},
+				"access_level": {"2"}, "password": {"password123"}, "active": {"1"}},
+			http.StatusSeeOther,
+		},
+		{
+			"deactivate user",
+			"/admin/users/2",
+			url.Values{"first_name": {"Fran"}, "last_name": {"Desk"}, "email": {"desk@here.com"},
+				"access_level": {"2"}},
+			http.StatusSeeOther,
+		},
+		{
+			"deactivate self",
+			"/admin/users/1",
+			url.Values{"first_name": {"Owen"}, "last_name": {"Owner"}, "email": {"owner@here.com"},
+				"access_level": {"3"}},
+			http.StatusOK,
+		},
+		{
+			"demote self",
+			"/admin/users/1",
+			url.Values{"first_name": {"Owen"}, "last_name": {"Owner"}, "email": {"owner@here.com"},
+				"access_level": {"1"}, "active": {"1"}},
+			http.StatusOK,
+		},
+		{
+			"failure to insert",
+			"/admin/users/new",
+			url.Values{"first_name": {"fail"}, "last_name": {"Doe"}, "email": {"jane@here.com"},
+				"access_level": {"2"}, "password": {"password123"}, "active": {"1"}},
+			http.StatusInternalServerError,
+		},
+		{
+			"failure to update",
+			"/admin/users/2",
+			url.Values{"first_name": {"fail"}, "last_name": {"Desk"}, "email": {"desk@here.com"},
+				"access_level": {"2"}, "active": {"1"}},
+			http.StatusInternalServerError,
+		},
+		{
+			"non-existent user",
+			"/admin/users/1000",
+			url.Values{"first_name": {"Jane"}},
+			http.StatusNotFound,
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = helpers.ContextWithUser(ctx, owner)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostShowUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostShowUser returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedMail       int
+	}{
+		{"known user", "desk@here.com", http.StatusSeeOther, "/user/login", 1},
+		{"unknown user", "nobody@here.com", http.StatusSeeOther, "/user/login", 0},
+		{"deactivated user", "gone@here.com", http.StatusSeeOther, "/user/login", 0},
+		{"invalid email", "not-an-email", http.StatusOK, "", 0},
+		{"database error", "broken@here.com", http.StatusInternalServerError, "", 0},
+	}
+
+	sentMail.Reset()
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostForgotPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		var expected []models.MailData
+		if e.expectedMail > 0 {
+			expected = append(expected, models.MailData{To: e.email, From: "bookings@here.com", Subject: "Password Reset"})
+		}
+		sent := checkSentMail(t, e.name, expected...)
+		if len(sent) == 1 && !strings.Contains(sent[0].Content, "http://localhost:8081/user/reset-password?token=") {
+			t.Errorf("%s: expected a reset link in the email, got %s", e.name, sent[0].Content)
+		}
+	}
+}
+
+func TestRepository_ResetPassword(t *testing.T) {
+	desk, _ := Repo.DB.GetUserByID(context.Background(), 2)
+	gone, _ := Repo.DB.GetUserByID(context.Background(), 4)
+	valid := Repo.passwordResetToken(desk, time.Now().Add(time.Hour))
+
+	// once the password has changed, old tokens must stop working
+	changed := desk
+	changed.Password = "$2a$12$someotherhash"
+
+	var tests = []struct {
+		name               string
+		token              string
+		password           string
+		passwordConfirm    string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid token", valid, "newpassword", "newpassword", http.StatusSeeOther, "/user/login"},
+		{"passwords don't match", valid, "newpassword", "otherpassword", http.StatusOK, ""},
+		{"password too short", valid, "short", "short", http.StatusOK, ""},
+		{"missing token", "", "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+		{"tampered token", valid + "x", "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+		{"expired token", Repo.passwordResetToken(desk, time.Now().Add(-time.Minute)), "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+		{"password already changed", Repo.passwordResetToken(changed, time.Now().Add(time.Hour)), "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+		{"deactivated user", Repo.passwordResetToken(gone, time.Now().Add(time.Hour)), "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+		{"unknown user", Repo.passwordResetToken(models.User{ID: 1000}, time.Now().Add(time.Hour)), "newpassword", "newpassword", http.StatusSeeOther, "/user/forgot-password"},
+	}
+
+	for _, e := range tests {
+		// the reset page
+		req, _ := http.NewRequest("GET", "/user/reset-password?token="+url.QueryEscape(e.token), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ShowResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedLocation == "/user/forgot-password" && rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: ShowResetPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if e.expectedLocation != "/user/forgot-password" && rr.Code != http.StatusOK {
+			t.Errorf("%s: ShowResetPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+
+		// posting a new password
+		postedData := url.Values{}
+		postedData.Add("token", e.token)
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.passwordConfirm)
+		req, _ = http.NewRequest("POST", "/user/reset-password", strings.NewReader(postedData.Encode()))
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr = httptest.NewRecorder()
+		handler = http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostResetPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_RoomCalendar(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	fetch := func() string {
+		resp, err := ts.Client().Get(ts.URL + "/rooms/1/calendar.ics?token=generals-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+			t.Errorf("calendar feed has wrong content type %s", resp.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	first := fetch()
+	for _, expected := range []string{
+		"X-WR-CALNAME:General's Quarters",
+		"UID:reservation-1@localhost",
+		"SUMMARY:Reserved",
+		"CATEGORIES:RESERVATION",
+		"UID:block-2@localhost",
+		"SUMMARY:Blocked by owner",
+		"CATEGORIES:OWNER-BLOCK",
+	} {
+		if !strings.Contains(first, expected) {
+			t.Errorf("expected calendar feed to contain %q", expected)
+		}
+	}
+
+	// guests' details are not shared
+	if strings.Contains(first, "John") || strings.Contains(first, "j@smith.com") {
+		t.Error("calendar feed contains guest details")
+	}
+
+	if strings.Count(first, "BEGIN:VEVENT") != 2 {
+		t.Errorf("expected 2 events, got %d", strings.Count(first, "BEGIN:VEVENT"))
+	}
+
+	// uids and stamps don't change between fetches, so subscribers don't see duplicates
+	if fetch() != first {
+		t.Error("calendar feed changed between two fetches")
+	}
+}
+
+func TestRepository_AdminRotateRoomICalToken(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "/admin/rooms/1/ical-token", http.StatusSeeOther, "/admin/rooms/1"},
+		{"bad id", "/admin/rooms/x/ical-token", http.StatusNotFound, ""},
+		{"unknown room", "/admin/rooms/2000/ical-token", http.StatusNotFound, ""},
+		{"db error", "/admin/rooms/2/ical-token", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminRotateRoomICalToken)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminRotateRoomICalToken returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomRate(t *testing.T) {
+	valid := url.Values{
+		"name":         {"Weekend"},
+		"start_date":   {"2050-01-01"},
+		"end_date":     {"2050-12-31"},
+		"nightly_rate": {"125.00"},
+		"weekdays":     {"5", "6"},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, x := range valid {
+			v[k] = x
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	var tests = []struct {
+		name               string
+		url                string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLocation   string
+		expectedSession    string
+	}{
+		{"valid", "/admin/rooms/1/rates", valid, http.StatusSeeOther, "/admin/rooms/1", "flash"},
+		{"missing name", "/admin/rooms/1/rates", with("name", " "), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"bad start date", "/admin/rooms/1/rates", with("start_date", "01/01/2050"), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"end before start", "/admin/rooms/1/rates", with("end_date", "2049-12-31"), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"bad rate", "/admin/rooms/1/rates", with("nightly_rate", "-5"), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"free rate", "/admin/rooms/1/rates", with("nightly_rate", "0"), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"bad weekday", "/admin/rooms/1/rates", with("weekdays", "7"), http.StatusSeeOther, "/admin/rooms/1", "error"},
+		{"bad id", "/admin/rooms/x/rates", valid, http.StatusNotFound, "", ""},
+		{"db error", "/admin/rooms/1/rates", with("name", "fail"), http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostRoomRate returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedSession != "" && session.GetString(ctx, e.expectedSession) == "" {
+			t.Errorf("%s: expected a %s message in the session", e.name, e.expectedSession)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomRate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "/admin/rooms/1/rates/1/delete", http.StatusSeeOther, "/admin/rooms/1"},
+		{"bad room id", "/admin/rooms/x/rates/1/delete", http.StatusNotFound, ""},
+		{"bad rate id", "/admin/rooms/1/rates/x/delete", http.StatusNotFound, ""},
+		{"db error", "/admin/rooms/1/rates/100/delete", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminDeleteRoomRate returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminImportRoomCalendar(t *testing.T) {
+	feed := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Partner//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:new@partner\r\nDTSTART;VALUE=DATE:20500501\r\nDTEND;VALUE=DATE:20500503\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	var tests = []struct {
+		name               string
+		url                string
+		file               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"upload with conflicts", "/admin/rooms/1/ical-import", feed, http.StatusOK, ""},
+		{"upload", "/admin/rooms/2/ical-import", feed, http.StatusOK, ""},
+		{"upload invalid file", "/admin/rooms/1/ical-import", "not a calendar", http.StatusSeeOther, "/admin/rooms/1"},
+		{"sync without import url", "/admin/rooms/1/ical-import", "", http.StatusSeeOther, "/admin/rooms/1"},
+		{"bad id", "/admin/rooms/x/ical-import", feed, http.StatusNotFound, ""},
+		{"unknown room", "/admin/rooms/2000/ical-import", feed, http.StatusNotFound, ""},
+		{"db error", "/admin/rooms/3/ical-import", feed, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		if e.file != "" {
+			fw, _ := mw.CreateFormFile("ics_file", "calendar.ics")
+			fw.Write([]byte(e.file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", e.url, body)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminImportRoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminImportRoomCalendar returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
 	if err != nil {
@@ -440,3 +1792,412 @@ func getCtx(req *http.Request) context.Context {
 	}
 	return ctx
 }
+
+// checkSentMail fails the test unless exactly the expected messages were sent, going by their
+// addresses and subjects, and returns them so their content can be checked. The recorder is
+// reset for the next test.
+func checkSentMail(t *testing.T, name string, expected ...models.MailData) []models.MailData {
+	t.Helper()
+
+	sent := sentMail.Messages()
+	sentMail.Reset()
+
+	if len(sent) != len(expected) {
+		t.Errorf("%s: expected %d emails to be sent, got %d: %+v", name, len(expected), len(sent), sent)
+		return sent
+	}
+	for i, x := range expected {
+		got := sent[i]
+		if got.To != x.To || got.From != x.From || got.Subject != x.Subject {
+			t.Errorf("%s: expected email %q from %s to %s, got %q from %s to %s",
+				name, x.Subject, x.From, x.To, got.Subject, got.From, got.To)
+		}
+	}
+	return sent
+}
+
+// checkInvite fails the test unless msg has a single calendar attachment with method, for the
+// booking with code, if it is given
+func checkInvite(t *testing.T, name string, msg models.MailData, method, code string) {
+	t.Helper()
+
+	if len(msg.Attachments) != 1 {
+		t.Errorf("%s: expected a calendar invite, got %d attachments", name, len(msg.Attachments))
+		return
+	}
+	a := msg.Attachments[0]
+	if a.ContentType != "text/calendar; charset=utf-8; method="+method {
+		t.Errorf("%s: wrong invite content type %q", name, a.ContentType)
+	}
+
+	expected := []string{"METHOD:" + method + "\r\n", "DTSTART;VALUE=DATE:", "ATTENDEE;", "mailto:" + msg.To}
+	if code != "" {
+		expected = append(expected, "UID:booking-"+code+"@localhost\r\n")
+	}
+	if method == "CANCEL" {
+		expected = append(expected, "STATUS:CANCELLED\r\n")
+	}
+	for _, x := range expected {
+		if !strings.Contains(string(a.Data), x) {
+			t.Errorf("%s: expected %q in the invite, got\n%s", name, x, a.Data)
+		}
+	}
+}
+
+func TestRepository_ShowManageReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid token", manageToken("BK-7F3K9Q"), http.StatusOK, "Cancel Reservation"},
+		{"cancelled reservation", manageToken("BK-CANCEL"), http.StatusOK, "has been cancelled"},
+		{"missing token", "", http.StatusSeeOther, ""},
+		{"tampered token", manageToken("BK-7F3K9Q") + "x", http.StatusSeeOther, ""},
+		{"unknown reservation", manageToken("BK-BROKEN"), http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/reservations/manage?token="+url.QueryEscape(e.token), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ShowManageReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_PostCancelReservation(t *testing.T) {
+	// reservation 1 was paid with fake_auth_1, so make sure the gateway knows about it
+	gateway := payments.NewFakeGateway()
+	authID, _ := gateway.Authorize(context.Background(), payments.FakeTokenApprove, 12500, "reservation-1")
+	_ = gateway.Capture(context.Background(), authID, 12500)
+
+	original := Repo.App.Payments
+	defer func() { Repo.App.Payments = original }()
+
+	var tests = []struct {
+		name               string
+		token              string
+		gateway            payments.PaymentGateway
+		expectedStatusCode int
+		expectedManagePage bool
+		expectedMail       bool
+		// expectedWarning is whether the guest is told their refund is still to come
+		expectedWarning bool
+	}{
+		{"paid reservation", manageToken("BK-7F3K9Q"), gateway, http.StatusSeeOther, true, true, false},
+		{"refund fails", manageToken("BK-7F3K9Q"), payments.NewFakeGateway(), http.StatusSeeOther, true, true, true},
+		{"unpaid reservation", manageToken("BK-UNPAID"), gateway, http.StatusSeeOther, true, true, false},
+		{"already cancelled", manageToken("BK-CANCEL"), gateway, http.StatusSeeOther, true, false, false},
+		{"can't cancel", manageToken("BK-FAILED"), gateway, http.StatusInternalServerError, false, false, false},
+		{"invalid token", "nope", gateway, http.StatusSeeOther, false, false, false},
+	}
+
+	sentMail.Reset()
+
+	for _, e := range tests {
+		Repo.App.Payments = e.gateway
+
+		postedData := url.Values{}
+		postedData.Add("token", e.token)
+		req, _ := http.NewRequest("POST", "/reservations/cancel", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			actualLoc, _ := rr.Result().Location()
+			if strings.HasPrefix(actualLoc.String(), "/reservations/manage") != e.expectedManagePage {
+				t.Errorf("%s: unexpected redirect to %s", e.name, actualLoc.String())
+			}
+		}
+		if session.Exists(ctx, "warning") != e.expectedWarning {
+			t.Errorf("%s: expected a warning in the session %v", e.name, e.expectedWarning)
+		}
+
+		if e.expectedMail {
+			sent := checkSentMail(t, e.name,
+				models.MailData{To: "j@smith.com", From: "bookings@here.com", Subject: "Reservation Cancelled"},
+				models.MailData{To: "owner@here.com", From: "bookings@here.com", Subject: "Cancellation Notification"},
+			)
+			if len(sent) == 2 {
+				checkInvite(t, e.name, sent[0], "CANCEL", "")
+			}
+		} else {
+			checkSentMail(t, e.name)
+		}
+	}
+
+	if left := gateway.Captured(authID); left != 0 {
+		t.Errorf("expected the payment to be refunded in full, but %d is still captured", left)
+	}
+}
+
+func TestRepository_takePayment_Cancelled(t *testing.T) {
+	// the guest gave up on the page, but the payment is seen through
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reservation := models.Reservation{ID: 1, RoomID: 1, Total: 12500}
+	if _, err := Repo.takePayment(ctx, reservation, payments.FakeTokenApprove); err != nil {
+		t.Errorf("expected the payment to go through after the request was cancelled, got %v", err)
+	}
+}
+
+func TestRepository_SweepPendingPayments(t *testing.T) {
+	original := Repo.App.Payments
+	defer func() { Repo.App.Payments = original }()
+
+	ctx := context.Background()
+	reference := paymentReference(1)
+
+	var tests = []struct {
+		name string
+		// setup is what happened at the gateway before it stopped answering
+		setup        func(g *payments.FakeGateway)
+		expectedPaid bool
+		// expectedHeld is whether the reservation is still waiting on the payment
+		expectedHeld bool
+	}{
+		{"never authorized", func(g *payments.FakeGateway) {}, false, false},
+		{"captured", func(g *payments.FakeGateway) {
+			id, _ := g.Authorize(ctx, payments.FakeTokenApprove, 12500, reference)
+			_ = g.Capture(ctx, id, 12500)
+		}, true, false},
+		{"authorized", func(g *payments.FakeGateway) {
+			_, _ = g.Authorize(ctx, payments.FakeTokenApprove, 12500, reference)
+		}, true, false},
+		{"voided", func(g *payments.FakeGateway) {
+			id, _ := g.Authorize(ctx, payments.FakeTokenApprove, 12500, reference)
+			_ = g.Void(ctx, id)
+		}, false, false},
+		{"capture refused", func(g *payments.FakeGateway) {
+			_, _ = g.Authorize(ctx, payments.FakeTokenCaptureFail, 12500, reference)
+		}, false, false},
+		{"capture times out", func(g *payments.FakeGateway) {
+			_, _ = g.Authorize(ctx, payments.FakeTokenCaptureTimeout, 12500, reference)
+		}, false, true},
+	}
+
+	sentMail.Reset()
+
+	for _, e := range tests {
+		gateway := payments.NewFakeGateway()
+		e.setup(gateway)
+		Repo.App.Payments = gateway
+
+		// the test repository has reservation 1 waiting on a payment, with its mail held
+		mail := []models.MailData{
+			{To: "j@smith.com", From: "bookings@here.com", Subject: "Reservation Confirmation"},
+			{To: "owner@here.com", From: "bookings@here.com", Subject: "Reservation Notification"},
+		}
+		_, _ = Repo.DB.InsertReservation(ctx, models.Reservation{RoomID: 1}, mail...)
+
+		Repo.SweepPendingPayments(ctx)
+
+		if e.expectedPaid {
+			checkSentMail(t, e.name, mail...)
+			if a, _ := gateway.Lookup(ctx, reference); a.Captured != 12500 {
+				t.Errorf("%s: expected the payment to be captured, got %+v", e.name, a)
+			}
+		} else {
+			checkSentMail(t, e.name)
+		}
+
+		if a, err := gateway.Lookup(ctx, reference); err == nil && !e.expectedPaid && !e.expectedHeld && !a.Voided {
+			t.Errorf("%s: expected the authorization of a released reservation to be voided", e.name)
+		}
+
+		_ = Repo.DB.ReleaseReservationMail(ctx, 1)
+		if held := len(sentMail.Messages()) > 0; held != e.expectedHeld {
+			t.Errorf("%s: expected the reservation to be held %v, got %v", e.name, e.expectedHeld, held)
+		}
+		sentMail.Reset()
+	}
+}
+
+func TestRepository_SweepPendingPayments_Refunds(t *testing.T) {
+	original := Repo.App.Payments
+	defer func() { Repo.App.Payments = original }()
+
+	// the test repository has half of fake_auth_1 still to be refunded
+	ctx := context.Background()
+	gateway := payments.NewFakeGateway()
+	authID, _ := gateway.Authorize(ctx, payments.FakeTokenApprove, 12500, "Reservation 9")
+	_ = gateway.Capture(ctx, authID, 12500)
+	Repo.App.Payments = gateway
+
+	Repo.SweepPendingPayments(ctx)
+
+	if left := gateway.Captured(authID); left != 6250 {
+		t.Errorf("expected the refund to be made again, but %d is still captured", left)
+	}
+	sentMail.Reset()
+}
+
+// manageToken returns a manage your booking token for the reservation with the given code
+func manageToken(code string) string {
+	res := models.Reservation{Code: code, EndDate: time.Now().Add(24 * time.Hour)}
+	u, _ := url.Parse(Repo.manageReservationURL(res))
+	return u.Query().Get("token")
+}
+
+func TestRepository_AdminSearchReservations(t *testing.T) {
+	var tests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"found", "BK-7F3K9Q", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"typed loosely", " bk 7f3k9q", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"cancelled", "BK-CANCEL", http.StatusSeeOther, "/admin/reservations/all/50"},
+		{"empty", "", http.StatusSeeOther, "/admin/reservations-all"},
+		{"database error", "BK-BROKEN", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-search?code="+url.QueryEscape(e.code), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminSearchReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminSearchReservations returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		status             string
+		expectedStatusCode int
+		expectedIDs        []int
+	}{
+		{"all", "", http.StatusOK, []int{1, 2}},
+		{"dead", "dead", http.StatusOK, []int{1}},
+		{"held", "held", http.StatusOK, nil},
+		{"unknown status", "lost", http.StatusBadRequest, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/mail?status="+e.status, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminMail returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		for _, id := range []int{1, 2} {
+			link := fmt.Sprintf(`href="/admin/mail/%d"`, id)
+			expected := false
+			for _, x := range e.expectedIDs {
+				expected = expected || x == id
+			}
+			if strings.Contains(rr.Body.String(), link) != expected {
+				t.Errorf("%s: message %d listed is %t, wanted %t", e.name, id, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminShowMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"dead", "/admin/mail/1", http.StatusOK, "dial tcp: connection refused"},
+		{"sent", "/admin/mail/2", http.StatusOK, "Password Reset"},
+		{"not found", "/admin/mail/3", http.StatusNotFound, ""},
+		{"invalid id", "/admin/mail/abc", http.StatusNotFound, ""},
+		{"database error", "/admin/mail/101", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminShowMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminShowMail returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminResendMail(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedWarning    bool
+	}{
+		{"dead", "/admin/mail/1/resend", http.StatusSeeOther, "/admin/mail/1", false},
+		{"already sent", "/admin/mail/2/resend", http.StatusSeeOther, "/admin/mail/2", true},
+		{"invalid id", "/admin/mail/abc/resend", http.StatusNotFound, "", false},
+		{"database error", "/admin/mail/100/resend", http.StatusInternalServerError, "", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminResendMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminResendMail returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if hasWarning := session.Exists(ctx, "warning"); hasWarning != e.expectedWarning {
+			t.Errorf("%s: warning in session is %t, wanted %t", e.name, hasWarning, e.expectedWarning)
+		}
+	}
+}
@@ -6,8 +6,15 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jjang65/booking-web-app/internal/cancellation"
 	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/emails"
+	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
+	"github.com/jjang65/booking-web-app/internal/mailer"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/payments"
+	"github.com/jjang65/booking-web-app/internal/pricing"
 	"github.com/jjang65/booking-web-app/internal/render"
 	"github.com/justinas/nosurf"
 	"html/template"
@@ -21,8 +28,22 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+
+// sentMail records the mail the handlers send, instead of sending it
+var sentMail = mailer.NewRecorder()
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":       render.HumanDate,
+	"formatDate":      render.FormatDate,
+	"iterate":         render.Iterate,
+	"formatMoney":     pricing.FormatMoney,
+	"weekdays":        pricing.WeekdayNames,
+	"statusLabel":     lifecycle.Label,
+	"accessLevels":    render.AccessLevels,
+	"accessLevelName": render.AccessLevelName,
+	"canEdit":         render.CanEdit,
+	"canManage":       render.CanManage,
+}
 
 func TestMain(m *testing.M) {
 	// Store Reservation type in the session
@@ -46,11 +67,20 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	app.SecretKey = []byte("test secret key")
+	app.BaseURL = "http://localhost:8081"
+	app.Payments = payments.NewFakeGateway()
+	app.Cancellation = cancellation.Policy{FreeDays: 7, LateRefundPercent: 50}
+	app.Mailer = sentMail
+	app.MailFrom = "bookings@here.com"
+	app.OwnerEmail = "owner@here.com"
+	app.MailReplyTo = "frontdesk@here.com"
 
-	listenForMail()
+	emailTemplates, err := emails.CreateTemplateCacheFrom("./../../email-templates")
+	if err != nil {
+		log.Fatal("cannot create email template cache")
+	}
+	app.EmailTemplates = emailTemplates
 
 	// Create templateCache initially to cache templates
 	tc, err := CreateTestTemplateCache()
@@ -70,19 +100,10 @@ func TestMain(m *testing.M) {
 	// Passing app reference to use app config in the render package
 	render.NewRenderer(&app)
 
-	os.Exit(m.Run())
-}
+	// Passing app reference to helpers
+	helpers.NewHelpers(&app)
 
-func listenForMail() {
-	// runs in the background
-	go func() {
-		// Listen for mail channel
-		for {
-			// Not sending msg because it is for testing
-			_ = <-app.MailChan
-			//sendMsg(msg)
-		}
-	}()
+	os.Exit(m.Run())
 }
 
 func getRoutes() http.Handler {
@@ -104,8 +125,11 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendar)
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -117,6 +141,48 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/rooms/{id}", Repo.APIRoom)
+		mux.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APICreateReservation)
+		mux.Get("/reservations/{code}", Repo.APIReservation)
+	})
+
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+
+	// Guests manage their own reservations through the link in their confirmation email
+	mux.Get("/reservations/manage", Repo.ShowManageReservation)
+	mux.Post("/reservations/cancel", Repo.PostCancelReservation)
+
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Post("/admin/rooms/{id}/ical-token", Repo.AdminRotateRoomICalToken)
+	mux.Post("/admin/rooms/{id}/ical-import", Repo.AdminImportRoomCalendar)
+	mux.Post("/admin/rooms/{id}/rates", Repo.AdminPostRoomRate)
+	mux.Post("/admin/rooms/{id}/rates/{rateID}/delete", Repo.AdminDeleteRoomRate)
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+	mux.Get("/admin/mail", Repo.AdminMail)
+	mux.Get("/admin/mail/{id}", Repo.AdminShowMail)
+	mux.Post("/admin/mail/{id}/resend", Repo.AdminResendMail)
+	mux.Get("/admin/reservations-search", Repo.AdminSearchReservations)
+	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
+	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Post("/admin/reservations/{src}/status", Repo.AdminUpdateReservationStatus)
+	mux.Get("/admin/reservations/{src}/{id}", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/delete", Repo.AdminDeleteReservation)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	return mux
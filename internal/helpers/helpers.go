@@ -1,14 +1,27 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/models"
 	"net/http"
+	"regexp"
 	"runtime/debug"
+	"strings"
 )
 
 var app *config.AppConfig
 
+var nonSlugChars = regexp.MustCompile("[^a-z0-9]+")
+
+type contextKey string
+
+// userContextKey is the request context key for the authenticated user
+const userContextKey = contextKey("user")
+
 // NewHelpers sets up app config for helpers
 func NewHelpers(a *config.AppConfig) {
 	app = a
@@ -29,3 +42,30 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ContextWithUser returns a copy of ctx holding the authenticated user
+func ContextWithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+// AuthUser returns the authenticated user put into the request context by the Auth middleware
+func AuthUser(r *http.Request) (models.User, bool) {
+	u, ok := r.Context().Value(userContextKey).(models.User)
+	return u, ok
+}
+
+// Slugify turns a name into a url friendly slug, e.g. "General's Quarters" becomes "generals-quarters"
+func Slugify(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, "'", ""))
+	s = nonSlugChars.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-")
+}
+
+// RandomToken returns a hex encoded random token made from n random bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,164 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the MIME type of iCalendar data
+const ContentType = "text/calendar; charset=utf-8"
+
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405Z"
+	maxLineLength  = 75
+)
+
+// Calendar is an iCalendar (RFC 5545) VCALENDAR object
+type Calendar struct {
+	ProdID string
+	// Name is shown by most clients as the calendar's title
+	Name string
+	// Method is the iTIP method, such as PUBLISH, REQUEST or CANCEL; it is left out when empty
+	Method string
+	Events []Event
+}
+
+// Event is a VEVENT; all-day events use the dates of Start and End, with End being exclusive
+type Event struct {
+	UID         string
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Categories  []string
+	Status      string
+	Sequence    int
+	// Organizer and Attendees are email addresses; invites sent with METHOD:REQUEST or CANCEL need an organizer
+	Organizer string
+	Attendees []string
+}
+
+// Bytes returns the calendar in iCalendar format
+func (c *Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	_, _ = c.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo writes the calendar to w in iCalendar format
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	lw := &lineWriter{w: w}
+
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + c.ProdID)
+	lw.line("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		lw.line("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+
+	for _, e := range c.Events {
+		lw.line("BEGIN:VEVENT")
+		lw.line("UID:" + e.UID)
+		lw.line("DTSTAMP:" + e.Stamp.UTC().Format(dateTimeLayout))
+		if e.AllDay {
+			lw.line("DTSTART;VALUE=DATE:" + e.Start.Format(dateLayout))
+			lw.line("DTEND;VALUE=DATE:" + e.End.Format(dateLayout))
+		} else {
+			lw.line("DTSTART:" + e.Start.UTC().Format(dateTimeLayout))
+			lw.line("DTEND:" + e.End.UTC().Format(dateTimeLayout))
+		}
+		if e.Sequence > 0 {
+			lw.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		}
+		lw.line("SUMMARY:" + escapeText(e.Summary))
+		if e.Description != "" {
+			lw.line("DESCRIPTION:" + escapeText(e.Description))
+		}
+		if e.Location != "" {
+			lw.line("LOCATION:" + escapeText(e.Location))
+		}
+		if e.URL != "" {
+			lw.line("URL:" + e.URL)
+		}
+		if len(e.Categories) > 0 {
+			var categories []string
+			for _, c := range e.Categories {
+				categories = append(categories, escapeText(c))
+			}
+			lw.line("CATEGORIES:" + strings.Join(categories, ","))
+		}
+		if e.Organizer != "" {
+			lw.line("ORGANIZER:mailto:" + e.Organizer)
+		}
+		for _, a := range e.Attendees {
+			lw.line("ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:" + a)
+		}
+		if e.Status != "" {
+			lw.line("STATUS:" + e.Status)
+		}
+		lw.line("TRANSP:OPAQUE")
+		lw.line("END:VEVENT")
+	}
+
+	lw.line("END:VCALENDAR")
+	return lw.n, lw.err
+}
+
+// escapeText escapes a TEXT property value
+func escapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// lineWriter writes content lines, folded at 75 octets and ended with CRLF, and remembers the first error
+type lineWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+
+	var b strings.Builder
+	limit := maxLineLength
+	for len(s) > limit {
+		// never split a multi-byte character
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// continuation lines start with a space, which counts towards the limit
+		limit = maxLineLength - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+
+	n, err := io.WriteString(lw.w, b.String())
+	lw.n += int64(n)
+	lw.err = err
+}
@@ -0,0 +1,133 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Bytes(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//EN",
+		Name:   "General's Quarters",
+		Events: []Event{
+			{
+				UID:        "reservation-1@bookings",
+				Stamp:      time.Date(2050, 1, 1, 12, 30, 0, 0, time.UTC),
+				Start:      time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+				End:        time.Date(2050, 2, 3, 0, 0, 0, 0, time.UTC),
+				AllDay:     true,
+				Summary:    "Reserved; room 1, upstairs",
+				Categories: []string{"RESERVATION"},
+				Status:     "CONFIRMED",
+			},
+		},
+	}
+
+	out := string(cal.Bytes())
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"VERSION:2.0\r\n",
+		"PRODID:-//Bookings//EN\r\n",
+		"X-WR-CALNAME:General's Quarters\r\n",
+		"UID:reservation-1@bookings\r\n",
+		"DTSTAMP:20500101T123000Z\r\n",
+		"DTSTART;VALUE=DATE:20500201\r\n",
+		"DTEND;VALUE=DATE:20500203\r\n",
+		"SUMMARY:Reserved\\; room 1\\, upstairs\r\n",
+		"CATEGORIES:RESERVATION\r\n",
+		"STATUS:CONFIRMED\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected calendar to contain %q, got\n%s", expected, out)
+		}
+	}
+
+	if strings.Contains(out, "METHOD:") {
+		t.Error("calendar without a method should not have a METHOD line")
+	}
+
+	// same input, same output, so feeds don't churn
+	if out != string(cal.Bytes()) {
+		t.Error("calendar output is not stable")
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"plain", "plain"},
+		{`back\slash`, `back\\slash`},
+		{"a;b,c", `a\;b\,c`},
+		{"line one\nline two\r\nline three", `line one\nline two\nline three`},
+	}
+
+	for _, e := range tests {
+		if got := escapeText(e.in); got != e.out {
+			t.Errorf("escapeText(%q) = %q, wanted %q", e.in, got, e.out)
+		}
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//EN",
+		Events: []Event{
+			{
+				UID:         "block-1@bookings",
+				Summary:     "Blocked",
+				Description: strings.Repeat("Ünïcödé ", 30),
+			},
+		},
+	}
+
+	out := string(cal.Bytes())
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line is longer than %d octets: %q", maxLineLength, line)
+		}
+		if !strings.HasPrefix(line, " ") && !strings.Contains(line, ":") {
+			t.Errorf("line is neither a property nor a continuation: %q", line)
+		}
+	}
+
+	// unfolding gives back the original value
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if !strings.Contains(unfolded, "DESCRIPTION:"+strings.Repeat("Ünïcödé ", 30)+"\r\n") {
+		t.Error("folded description does not unfold to the original value")
+	}
+}
+
+func TestCalendar_Invite(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//EN",
+		Method: "CANCEL",
+		Events: []Event{
+			{
+				UID:       "booking-BK-7F3K9Q@bookings",
+				Summary:   "Stay at Fort Smythe",
+				Sequence:  1,
+				Organizer: "bookings@here.com",
+				Attendees: []string{"john@smith.com"},
+				Status:    "CANCELLED",
+			},
+		},
+	}
+
+	out := string(cal.Bytes())
+	for _, expected := range []string{
+		"METHOD:CANCEL\r\n",
+		"SEQUENCE:1\r\n",
+		"ORGANIZER:mailto:bookings@here.com\r\n",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:john@smith.com\r\n",
+		"STATUS:CANCELLED\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected calendar to contain %q, got\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCalendar is returned by Parse when the data has no VCALENDAR object
+var ErrNoCalendar = errors.New("no VCALENDAR found")
+
+// durationRegexp matches the week, day, hour, minute and second parts of a DURATION value
+var durationRegexp = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// property is one unfolded content line
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the first VCALENDAR in r. Only the parts of events that matter for
+// availability are kept, and recurring events are read as their first occurrence.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cal *Calendar
+	var event *Event
+	var depth int
+	// DURATION may come before DTSTART, so it is applied when the event ends
+	var duration time.Duration
+	var durationDays int
+	var hasDuration bool
+
+	for i, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VCALENDAR") && cal == nil:
+			cal = &Calendar{}
+		case p.name == "END" && strings.EqualFold(p.value, "VCALENDAR") && cal != nil:
+			return cal, nil
+		case cal == nil:
+			continue
+		case p.name == "BEGIN":
+			depth++
+			if depth == 1 && strings.EqualFold(p.value, "VEVENT") {
+				event = &Event{}
+				hasDuration = false
+			}
+		case p.name == "END":
+			if depth == 1 && event != nil {
+				if event.UID == "" {
+					return nil, fmt.Errorf("line %d: event without UID", i+1)
+				}
+				if event.End.IsZero() && hasDuration {
+					event.End = event.Start.AddDate(0, 0, durationDays).Add(duration)
+				}
+				if event.End.IsZero() {
+					// an event without an end lasts one day, or no time at all
+					event.End = event.Start
+					if event.AllDay {
+						event.End = event.Start.AddDate(0, 0, 1)
+					}
+				}
+				cal.Events = append(cal.Events, *event)
+				event = nil
+			}
+			depth--
+		case depth == 0:
+			switch p.name {
+			case "PRODID":
+				cal.ProdID = p.value
+			case "METHOD":
+				cal.Method = p.value
+			case "X-WR-CALNAME":
+				cal.Name = unescapeText(p.value)
+			}
+		case depth == 1 && event != nil && p.name == "DURATION":
+			duration, durationDays, err = parseDuration(p.value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			hasDuration = true
+		case depth == 1 && event != nil:
+			err = event.setProperty(p)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+
+	if cal == nil {
+		return nil, ErrNoCalendar
+	}
+	return nil, errors.New("VCALENDAR is not closed")
+}
+
+// setProperty copies one property of a VEVENT into e
+func (e *Event) setProperty(p property) error {
+	var err error
+
+	switch p.name {
+	case "UID":
+		e.UID = p.value
+	case "SUMMARY":
+		e.Summary = unescapeText(p.value)
+	case "DESCRIPTION":
+		e.Description = unescapeText(p.value)
+	case "LOCATION":
+		e.Location = unescapeText(p.value)
+	case "URL":
+		e.URL = p.value
+	case "STATUS":
+		e.Status = strings.ToUpper(p.value)
+	case "SEQUENCE":
+		e.Sequence, _ = strconv.Atoi(p.value)
+	case "CATEGORIES":
+		for _, c := range splitList(p.value) {
+			e.Categories = append(e.Categories, unescapeText(c))
+		}
+	case "DTSTAMP":
+		e.Stamp, _, err = parseTime(p)
+	case "DTSTART":
+		e.Start, e.AllDay, err = parseTime(p)
+	case "DTEND":
+		e.End, _, err = parseTime(p)
+	}
+	return err
+}
+
+// unfold reads content lines, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseProperty splits a content line into its name, parameters and value
+func parseProperty(line string) (property, error) {
+	p := property{params: make(map[string]string)}
+
+	// the value starts at the first colon that isn't inside a quoted parameter value
+	inQuotes := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("malformed content line %q", line)
+	}
+	p.value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return p, nil
+}
+
+// parseTime reads a DATE or DATE-TIME property, and says whether it was a DATE
+func parseTime(p property) (time.Time, bool, error) {
+	if p.params["VALUE"] == "DATE" || len(p.value) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, p.value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(p.value, "Z") {
+		t, err := time.Parse(dateTimeLayout, p.value)
+		return t, false, err
+	}
+
+	// floating times, or times in a named zone; zones we don't know are read as UTC
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", p.value, loc)
+	return t, false, err
+}
+
+// parseDuration reads a DURATION value as whole days plus the remaining time
+func parseDuration(s string) (time.Duration, int, error) {
+	m := durationRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, fmt.Errorf("malformed duration %q", s)
+	}
+
+	n := func(i int) int {
+		v, _ := strconv.Atoi(m[i])
+		return v
+	}
+
+	days := n(2)*7 + n(3)
+	d := time.Duration(n(4))*time.Hour + time.Duration(n(5))*time.Minute + time.Duration(n(6))*time.Second
+	if m[1] == "-" {
+		return -d, -days, nil
+	}
+	return d, days, nil
+}
+
+// splitList splits a list of TEXT values on the commas that aren't escaped
+func splitList(s string) []string {
+	var list []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == ',' {
+			list = append(list, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, s[start:])
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
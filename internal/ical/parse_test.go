@@ -0,0 +1,151 @@
+package ical
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Partner//EN\r\n" +
+	"X-WR-CALNAME:Cabin\\, upstairs\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:America/Halifax\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19701101T020000\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:all-day@partner\r\n" +
+	"DTSTAMP:20500101T120000Z\r\n" +
+	"DTSTART;VALUE=DATE:20500201\r\n" +
+	"DTEND;VALUE=DATE:20500204\r\n" +
+	"SUMMARY:Booked\\; via partner\r\n" +
+	"DESCRIPTION:A long description that goes on and on and on so that it has to b\r\n" +
+	" e folded\\nover two lines\r\n" +
+	"CATEGORIES:A\\,B,C\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"UID:not-an-event\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:timed@partner\r\n" +
+	"DTSTART;TZID=\"America/Halifax\":20500301T150000\r\n" +
+	"DURATION:P2DT20H\r\n" +
+	"STATUS:cancelled\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500401\r\n" +
+	"UID:no-end@partner\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cal.ProdID != "-//Partner//EN" || cal.Name != "Cabin, upstairs" {
+		t.Errorf("wrong calendar properties: %q %q", cal.ProdID, cal.Name)
+	}
+	if len(cal.Events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(cal.Events))
+	}
+
+	e := cal.Events[0]
+	if e.UID != "all-day@partner" || !e.AllDay {
+		t.Errorf("wrong first event: %+v", e)
+	}
+	if !e.Start.Equal(time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)) || !e.End.Equal(time.Date(2050, 2, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong dates for first event: %s to %s", e.Start, e.End)
+	}
+	if e.Summary != "Booked; via partner" {
+		t.Errorf("wrong summary: %q", e.Summary)
+	}
+	if e.Description != "A long description that goes on and on and on so that it has to be folded\nover two lines" {
+		t.Errorf("wrong description: %q", e.Description)
+	}
+	if len(e.Categories) != 2 || e.Categories[0] != "A,B" {
+		t.Errorf("wrong categories: %q", e.Categories)
+	}
+	if !e.Stamp.Equal(time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong stamp: %s", e.Stamp)
+	}
+
+	e = cal.Events[1]
+	halifax, err := time.LoadLocation("America/Halifax")
+	if err == nil && !e.Start.Equal(time.Date(2050, 3, 1, 15, 0, 0, 0, halifax)) {
+		t.Errorf("wrong start for timed event: %s", e.Start)
+	}
+	if e.AllDay || !e.End.Equal(e.Start.AddDate(0, 0, 2).Add(20*time.Hour)) {
+		t.Errorf("wrong end for timed event: %s", e.End)
+	}
+	if e.Status != "CANCELLED" {
+		t.Errorf("wrong status: %q", e.Status)
+	}
+
+	// an all-day event without an end lasts one day
+	e = cal.Events[2]
+	if !e.End.Equal(e.Start.AddDate(0, 0, 1)) {
+		t.Errorf("wrong end for event without one: %s", e.End)
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	in := Calendar{
+		ProdID: "-//Bookings//EN",
+		Name:   "Room; one",
+		Events: []Event{
+			{
+				UID:         "reservation-1@bookings",
+				Stamp:       time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+				Start:       time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2050, 2, 3, 0, 0, 0, 0, time.UTC),
+				AllDay:      true,
+				Summary:     "Reserved",
+				Description: strings.Repeat("line, with; specials\n", 10),
+			},
+		},
+	}
+
+	out, err := Parse(strings.NewReader(string(in.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != in.Name || len(out.Events) != 1 {
+		t.Fatalf("round trip lost data: %+v", out)
+	}
+	if out.Events[0].Description != in.Events[0].Description {
+		t.Errorf("round trip changed the description: %q", out.Events[0].Description)
+	}
+	if !out.Events[0].Start.Equal(in.Events[0].Start) || !out.Events[0].End.Equal(in.Events[0].End) {
+		t.Error("round trip changed the dates")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		in   string
+	}{
+		{"not a calendar", "hello world\r\n"},
+		{"not closed", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\n"},
+		{"event without uid", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20500101T000000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"bad date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART;VALUE=DATE:2050-01-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"bad duration", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDURATION:two days\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.in)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader("")); !errors.Is(err, ErrNoCalendar) {
+		t.Errorf("expected ErrNoCalendar for empty input, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package icalimport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/ical"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/repository"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxFeedSize is the largest calendar we are willing to read
+const maxFeedSize = 5 << 20
+
+// ErrNoImportURL is returned by Fetch for rooms that don't have a calendar to import from
+var ErrNoImportURL = errors.New("room has no calendar import url")
+
+// Importer syncs bookings made on other platforms into a room's restrictions
+type Importer struct {
+	DB     repository.DatabaseRepo
+	Client *http.Client
+	// OwnUIDDomain is the domain of the uids in our own feeds; events with those uids are
+	// skipped, so a partner that echoes our feed back doesn't block our own reservations
+	OwnUIDDomain string
+}
+
+// Conflict is an imported event that overlaps a reservation made with us
+type Conflict struct {
+	UID           string
+	Summary       string
+	StartDate     time.Time
+	EndDate       time.Time
+	ReservationID int
+}
+
+// Report says what an import changed
+type Report struct {
+	Room      models.Room
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+	Skipped   int
+	Conflicts []Conflict
+}
+
+// New returns an Importer using db and an http client with a sensible timeout
+func New(db repository.DatabaseRepo, ownUIDDomain string) *Importer {
+	return &Importer{
+		DB:           db,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		OwnUIDDomain: ownUIDDomain,
+	}
+}
+
+// Fetch imports the calendar at the room's import url, which must be an http or https url. Local
+// files aren't read, so whoever can edit a room can't make the server show them its files.
+func (im *Importer) Fetch(ctx context.Context, room models.Room) (Report, error) {
+	if room.ICalImportURL == "" {
+		return Report{Room: room}, ErrNoImportURL
+	}
+
+	u, err := url.Parse(room.ICalImportURL)
+	if err != nil {
+		return Report{Room: room}, err
+	}
+
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return Report{Room: room}, err
+		}
+		resp, err := im.Client.Do(req)
+		if err != nil {
+			return Report{Room: room}, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return Report{Room: room}, fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status)
+		}
+		body = resp.Body
+	default:
+		return Report{Room: room}, fmt.Errorf("unsupported calendar url scheme %q", u.Scheme)
+	}
+	defer body.Close()
+
+	return im.Import(ctx, room, body)
+}
+
+// Import reads calendar data and makes the room's external restrictions match its events.
+// Running it again with the same data changes nothing.
+func (im *Importer) Import(ctx context.Context, room models.Room, r io.Reader) (Report, error) {
+	report := Report{Room: room}
+
+	cal, err := ical.Parse(io.LimitReader(r, maxFeedSize))
+	if err != nil {
+		return report, err
+	}
+
+	existing, err := im.DB.GetExternalRestrictionsForRoom(ctx, room.ID)
+	if err != nil {
+		return report, err
+	}
+	byUID := make(map[string]models.RoomRestriction)
+	var deleteIDs []int
+	for _, x := range existing {
+		if _, ok := byUID[x.ExternalUID]; ok {
+			// only one restriction per event
+			deleteIDs = append(deleteIDs, x.ID)
+			continue
+		}
+		byUID[x.ExternalUID] = x
+	}
+
+	var inserts, updates []models.RoomRestriction
+	seen := make(map[string]bool)
+
+	for _, e := range cal.Events {
+		if seen[e.UID] || e.Status == "CANCELLED" || im.isOwnEvent(e) {
+			report.Skipped++
+			continue
+		}
+		seen[e.UID] = true
+
+		startDate, endDate := nights(e)
+		rr := models.RoomRestriction{
+			StartDate:     startDate,
+			EndDate:       endDate,
+			RoomID:        room.ID,
+			RestrictionID: models.RestrictionExternal,
+			ExternalUID:   e.UID,
+		}
+
+		conflicts, err := im.conflicts(ctx, room.ID, e, startDate, endDate)
+		if err != nil {
+			return report, err
+		}
+		report.Conflicts = append(report.Conflicts, conflicts...)
+
+		old, ok := byUID[e.UID]
+		switch {
+		case !ok:
+			inserts = append(inserts, rr)
+			report.Added++
+		case !sameDay(old.StartDate, startDate) || !sameDay(old.EndDate, endDate):
+			rr.ID = old.ID
+			updates = append(updates, rr)
+			report.Updated++
+		default:
+			report.Unchanged++
+		}
+	}
+
+	// events that are gone from the feed were cancelled or moved elsewhere
+	for uid, x := range byUID {
+		if !seen[uid] {
+			deleteIDs = append(deleteIDs, x.ID)
+			report.Removed++
+		}
+	}
+
+	if len(inserts) == 0 && len(updates) == 0 && len(deleteIDs) == 0 {
+		return report, nil
+	}
+
+	err = im.DB.SyncExternalRestrictions(ctx, room.ID, inserts, updates, deleteIDs)
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// isOwnEvent says whether e came from one of our own feeds
+func (im *Importer) isOwnEvent(e ical.Event) bool {
+	return im.OwnUIDDomain != "" && strings.HasSuffix(strings.ToLower(e.UID), "@"+strings.ToLower(im.OwnUIDDomain))
+}
+
+// conflicts returns the reservations made with us that overlap an event
+func (im *Importer) conflicts(ctx context.Context, roomID int, e ical.Event, startDate, endDate time.Time) ([]Conflict, error) {
+	restrictions, err := im.DB.GetRestrictionsForRoomByDate(ctx, roomID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	for _, x := range restrictions {
+		if x.RestrictionID != models.RestrictionReservation {
+			continue
+		}
+		if x.StartDate.Before(endDate) && x.EndDate.After(startDate) {
+			conflicts = append(conflicts, Conflict{
+				UID:           e.UID,
+				Summary:       e.Summary,
+				StartDate:     startDate,
+				EndDate:       endDate,
+				ReservationID: x.ReservationID,
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// nights returns the first night and the departure day of an event; an event
+// that starts and ends on the same day still takes that night
+func nights(e ical.Event) (time.Time, time.Time) {
+	startDate := date(e.Start)
+	endDate := date(e.End)
+	if !endDate.After(startDate) {
+		endDate = startDate.AddDate(0, 0, 1)
+	}
+	return startDate, endDate
+}
+
+// date returns the calendar date of t, in t's own location, as midnight UTC
+func date(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sameDay(a, b time.Time) bool {
+	return date(a).Equal(date(b))
+}
@@ -0,0 +1,165 @@
+package icalimport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// feed builds a calendar from "uid start end [status]" lines, with dates as YYYYMMDD
+func feed(events ...string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Partner//EN\r\n")
+	for _, e := range events {
+		f := strings.Fields(e)
+		b.WriteString("BEGIN:VEVENT\r\n")
+		fmt.Fprintf(&b, "UID:%s\r\nDTSTART;VALUE=DATE:%s\r\nDTEND;VALUE=DATE:%s\r\nSUMMARY:Booked\r\n", f[0], f[1], f[2])
+		if len(f) > 3 {
+			fmt.Fprintf(&b, "STATUS:%s\r\n", f[3])
+		}
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	return b.String()
+}
+
+func newTestImporter() *Importer {
+	return New(dbrepo.NewTestingRepo(&config.AppConfig{}), "localhost")
+}
+
+func TestImporter_Import(t *testing.T) {
+	im := newTestImporter()
+
+	// the test repo already has moved@partner on 2050-03-01 and removed@partner on 2050-04-01
+	data := feed(
+		"moved@partner 20500305 20500307",
+		"new@partner 20500501 20500503",
+		"new@partner 20500601 20500603",
+		"cancelled@partner 20500701 20500703 CANCELLED",
+		"reservation-1@localhost 20500801 20500803",
+	)
+
+	report, err := im.Import(context.Background(), models.Room{ID: 2}, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Added != 1 || report.Updated != 1 || report.Removed != 1 || report.Unchanged != 0 || report.Skipped != 3 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if len(report.Conflicts) != 0 {
+		t.Errorf("expected no conflicts in room 2, got %d", len(report.Conflicts))
+	}
+}
+
+func TestImporter_ImportIsIdempotent(t *testing.T) {
+	im := newTestImporter()
+
+	data := feed(
+		"moved@partner 20500301 20500303",
+		"removed@partner 20500401 20500403",
+	)
+
+	report, err := im.Import(context.Background(), models.Room{ID: 2}, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchanged != 2 || report.Added+report.Updated+report.Removed != 0 {
+		t.Errorf("re-importing the same events changed something: %+v", report)
+	}
+
+	// nothing changes, so nothing is written, even for a room that can't be saved
+	_, err = im.Import(context.Background(), models.Room{ID: 1000}, strings.NewReader(data))
+	if err != nil {
+		t.Errorf("expected no write for an unchanged feed, got %v", err)
+	}
+}
+
+func TestImporter_Conflicts(t *testing.T) {
+	im := newTestImporter()
+
+	// in room 1, the test repo has a reservation at the start of every date range
+	report, err := im.Import(context.Background(), models.Room{ID: 1}, strings.NewReader(feed("new@partner 20500501 20500503")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(report.Conflicts))
+	}
+	c := report.Conflicts[0]
+	if c.UID != "new@partner" || c.ReservationID != 1 || c.StartDate.Format("2006-01-02") != "2050-05-01" {
+		t.Errorf("wrong conflict: %+v", c)
+	}
+
+	// conflicting events are still imported, the room really is taken
+	if report.Added != 1 {
+		t.Errorf("conflicting event was not imported: %+v", report)
+	}
+}
+
+func TestImporter_ImportErrors(t *testing.T) {
+	im := newTestImporter()
+
+	var tests = []struct {
+		name string
+		room models.Room
+		data string
+	}{
+		{"invalid calendar", models.Room{ID: 1}, "not a calendar"},
+		{"can't load existing restrictions", models.Room{ID: 100}, feed("new@partner 20500501 20500503")},
+		{"can't save restrictions", models.Room{ID: 1000}, feed("new@partner 20500501 20500503")},
+	}
+
+	for _, e := range tests {
+		if _, err := im.Import(context.Background(), e.room, strings.NewReader(e.data)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestImporter_Fetch(t *testing.T) {
+	im := newTestImporter()
+	data := feed("new@partner 20500501 20500503")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendar.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer ts.Close()
+
+	var tests = []struct {
+		name      string
+		url       string
+		expectErr bool
+	}{
+		{"http", ts.URL + "/calendar.ics", false},
+		{"http not found", ts.URL + "/missing.ics", true},
+		{"local file", "file:///etc/passwd", true},
+		{"unsupported scheme", "ftp://example.com/calendar.ics", true},
+	}
+
+	for _, e := range tests {
+		report, err := im.Fetch(context.Background(), models.Room{ID: 2, ICalImportURL: e.url})
+		if e.expectErr && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+		if !e.expectErr && (err != nil || report.Added != 1) {
+			t.Errorf("%s: expected one event to be added, got %+v, %v", e.name, report, err)
+		}
+	}
+
+	if _, err := im.Fetch(context.Background(), models.Room{ID: 2}); !errors.Is(err, ErrNoImportURL) {
+		t.Errorf("expected ErrNoImportURL for a room without an import url, got %v", err)
+	}
+}
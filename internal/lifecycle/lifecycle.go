@@ -0,0 +1,96 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+)
+
+// ErrInvalidTransition is returned when a reservation can't move to the status asked for
+var ErrInvalidTransition = errors.New("invalid reservation status change")
+
+// transitions lists, for every status, the statuses a reservation can move to next
+var transitions = map[string][]string{
+	models.ReservationPending:    {models.ReservationConfirmed, models.ReservationCancelled},
+	models.ReservationConfirmed:  {models.ReservationCheckedIn, models.ReservationCancelled, models.ReservationNoShow},
+	models.ReservationCheckedIn:  {models.ReservationCheckedOut},
+	models.ReservationCheckedOut: nil,
+	models.ReservationCancelled:  nil,
+	models.ReservationNoShow:     nil,
+}
+
+// labels are the names of statuses as people see them
+var labels = map[string]string{
+	models.ReservationPending:    "Pending",
+	models.ReservationConfirmed:  "Confirmed",
+	models.ReservationCheckedIn:  "Checked in",
+	models.ReservationCheckedOut: "Checked out",
+	models.ReservationCancelled:  "Cancelled",
+	models.ReservationNoShow:     "No-show",
+}
+
+// Statuses returns every reservation status, in the order a reservation goes through them
+func Statuses() []string {
+	return []string{
+		models.ReservationPending,
+		models.ReservationConfirmed,
+		models.ReservationCheckedIn,
+		models.ReservationCheckedOut,
+		models.ReservationCancelled,
+		models.ReservationNoShow,
+	}
+}
+
+// Valid says whether status is a reservation status
+func Valid(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// Next returns the statuses a reservation in status from can move to
+func Next(from string) []string {
+	return transitions[from]
+}
+
+// CanTransition says whether a reservation can move from one status to another
+func CanTransition(from, to string) bool {
+	for _, x := range transitions[from] {
+		if x == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error wrapping ErrInvalidTransition unless a reservation can move from one status to another
+func Check(from, to string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, Label(from), Label(to))
+	}
+	return nil
+}
+
+// HoldsRoom says whether a reservation in status keeps its room off the market;
+// cancelled reservations and no-shows give the room back
+func HoldsRoom(status string) bool {
+	return status != models.ReservationCancelled && status != models.ReservationNoShow
+}
+
+// Released returns the statuses of reservations that no longer hold their room
+func Released() []string {
+	var released []string
+	for _, x := range Statuses() {
+		if !HoldsRoom(x) {
+			released = append(released, x)
+		}
+	}
+	return released
+}
+
+// Label returns the name of a status as people see it
+func Label(status string) string {
+	if l, ok := labels[status]; ok {
+		return l
+	}
+	return status
+}
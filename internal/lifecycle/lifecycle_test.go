@@ -0,0 +1,64 @@
+package lifecycle
+
+import (
+	"errors"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	var tests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{models.ReservationPending, models.ReservationConfirmed, true},
+		{models.ReservationPending, models.ReservationCancelled, true},
+		{models.ReservationPending, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, true},
+		{models.ReservationConfirmed, models.ReservationNoShow, true},
+		{models.ReservationConfirmed, models.ReservationPending, false},
+		{models.ReservationCheckedIn, models.ReservationCheckedOut, true},
+		{models.ReservationCheckedIn, models.ReservationCancelled, false},
+		{models.ReservationCheckedOut, models.ReservationCheckedIn, false},
+		{models.ReservationCancelled, models.ReservationConfirmed, false},
+		{models.ReservationNoShow, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationConfirmed, false},
+		{"unknown", models.ReservationConfirmed, false},
+	}
+
+	for _, e := range tests {
+		if got := CanTransition(e.from, e.to); got != e.expected {
+			t.Errorf("CanTransition(%s, %s) = %t, wanted %t", e.from, e.to, got, e.expected)
+		}
+		if err := Check(e.from, e.to); (err == nil) != e.expected {
+			t.Errorf("Check(%s, %s) = %v", e.from, e.to, err)
+		} else if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Check(%s, %s) did not wrap ErrInvalidTransition: %v", e.from, e.to, err)
+		}
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	for _, x := range Statuses() {
+		if !Valid(x) {
+			t.Errorf("%s is listed but not valid", x)
+		}
+		if Label(x) == x {
+			t.Errorf("%s has no label", x)
+		}
+		for _, to := range Next(x) {
+			if !Valid(to) {
+				t.Errorf("%s can move to unknown status %s", x, to)
+			}
+		}
+	}
+	if Valid("processed") {
+		t.Error("unknown status is valid")
+	}
+
+	released := Released()
+	if len(released) != 2 || released[0] != models.ReservationCancelled || released[1] != models.ReservationNoShow {
+		t.Errorf("wrong released statuses: %v", released)
+	}
+}
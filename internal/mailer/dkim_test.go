@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/toorop/go-dkim"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDKIM writes a new private key to a temporary file, and returns the settings to sign with it,
+// along with a lookup that serves the public key's TXT record, so signatures are verified offline
+func testDKIM(t *testing.T) (DKIMConfig, dkim.DNSOpt) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+
+	lookup := dkim.DNSOptLookupTXT(func(name string) ([]string, error) {
+		if name != "mail._domainkey.here.com" {
+			t.Errorf("looked up the wrong record %s", name)
+		}
+		return []string{record}, nil
+	})
+	return DKIMConfig{Domain: "here.com", Selector: "mail", KeyFile: keyFile}, lookup
+}
+
+func TestNewDKIMSigner(t *testing.T) {
+	cfg, _ := testDKIM(t)
+	badKey := filepath.Join(t.TempDir(), "bad.pem")
+	_ = os.WriteFile(badKey, []byte("not a key"), 0600)
+
+	var tests = []struct {
+		name      string
+		cfg       DKIMConfig
+		expectNil bool
+		expectErr bool
+	}{
+		{"not configured", DKIMConfig{}, true, false},
+		{"configured", cfg, false, false},
+		{"missing selector", DKIMConfig{Domain: "here.com", KeyFile: cfg.KeyFile}, true, true},
+		{"missing key file", DKIMConfig{Domain: "here.com", Selector: "mail", KeyFile: "nope.pem"}, true, true},
+		{"bad key", DKIMConfig{Domain: "here.com", Selector: "mail", KeyFile: badKey}, true, true},
+	}
+
+	for _, e := range tests {
+		s, err := newDKIMSigner(e.cfg)
+		if (err != nil) != e.expectErr {
+			t.Errorf("%s: expected error to be %t, got %v", e.name, e.expectErr, err)
+		}
+		if (s == nil) != e.expectNil {
+			t.Errorf("%s: expected signer to be nil: %t, got %v", e.name, e.expectNil, s)
+		}
+	}
+}
+
+func TestFileMailer_SendSigned(t *testing.T) {
+	cfg, lookup := testDKIM(t)
+	dir := t.TempDir()
+
+	m, err := NewFileMailer(Config{Dir: dir, DKIM: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessage
+	msg.ReplyTo = "frontdesk@here.com"
+	msg.ReturnPath = "bounces@here.com"
+	msg.ListUnsubscribe = "<mailto:unsubscribe@here.com>"
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 message in new, got %d", len(files))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, _ := readMessage(t, string(data))
+	if header.Get("Reply-To") != "<frontdesk@here.com>" || header.Get("Return-Path") != "<bounces@here.com>" ||
+		header.Get("List-Unsubscribe") != "<mailto:unsubscribe@here.com>" {
+		t.Errorf("wrong headers: %v", header)
+	}
+
+	signature, err := dkim.GetHeader(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"from", "to", "subject", "reply-to", "list-unsubscribe"} {
+		if !contains(signature.Headers, h) {
+			t.Errorf("expected %s to be signed, signed headers are %v", h, signature.Headers)
+		}
+	}
+	if contains(signature.Headers, "return-path") {
+		t.Error("return-path should not be signed")
+	}
+
+	status, err := dkim.Verify(&data, lookup)
+	if status != dkim.SUCCESS {
+		t.Errorf("expected the signature to verify, got %v: %v", status, err)
+	}
+
+	// changing a signed header breaks the signature
+	tampered := []byte(strings.Replace(string(data), "Subject: Reservation Confirmation", "Subject: Reservation Cancelled", 1))
+	status, _ = dkim.Verify(&tampered, lookup)
+	if status != dkim.PERMFAIL {
+		t.Errorf("expected a tampered message to fail verification, got %v", status)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
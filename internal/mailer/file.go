@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes messages to a maildir instead of sending them, for development
+type FileMailer struct {
+	dir      string
+	hostname string
+	count    uint64
+	dkim     *dkimSigner
+}
+
+// NewFileMailer returns a mailer writing to the maildir in cfg, creating it if needed
+func NewFileMailer(cfg Config) (*FileMailer, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		return nil, fmt.Errorf("mail directory is required")
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dkim, err := newDKIMSigner(cfg.DKIM)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &FileMailer{dir: dir, hostname: hostname, dkim: dkim}, nil
+}
+
+// Send writes a message to the maildir; it is written to tmp and moved to new,
+// so mail readers never see half a message
+func (f *FileMailer) Send(msg models.MailData) error {
+	email, err := buildEmail(msg, f.dkim)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&f.count, 1), f.hostname)
+	tmp := filepath.Join(f.dir, "tmp", name)
+
+	// like a delivery agent, record the envelope sender, which is where bounces go
+	data := fmt.Sprintf("Return-Path: <%s>\r\n%s", email.GetFrom(), messageText(email))
+	err = os.WriteFile(tmp, []byte(data), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(f.dir, "new", name))
+}
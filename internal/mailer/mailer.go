@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer hands email messages on to be delivered
+type Mailer interface {
+	Send(msg models.MailData) error
+}
+
+// Transports that can be chosen in the Config
+const (
+	TransportSMTP   = "smtp"
+	TransportFile   = "file"
+	TransportMemory = "memory"
+)
+
+// Config chooses a transport, and holds its settings
+type Config struct {
+	// Transport is one of TransportSMTP, TransportFile or TransportMemory
+	Transport string
+
+	// SMTP server settings; Encryption is one of EncryptionNone, EncryptionSTARTTLS or EncryptionTLS
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+
+	// Dir is the maildir the file transport writes to
+	Dir string
+
+	// DKIM signs the mail sent by the smtp and file transports
+	DKIM DKIMConfig
+}
+
+// New returns the mailer chosen by cfg
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Transport {
+	case TransportSMTP:
+		return NewSMTPMailer(cfg)
+	case TransportFile:
+		return NewFileMailer(cfg)
+	case TransportMemory:
+		return NewRecorder(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.Transport)
+	}
+}
+
+// buildEmail turns a message into an email, with its plain text and HTML bodies as alternatives,
+// followed by its attachments. The email is signed if dkim isn't nil.
+func buildEmail(msg models.MailData, dkim *dkimSigner) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+	if msg.ReplyTo != "" {
+		email.SetReplyTo(msg.ReplyTo)
+	}
+	if msg.ReturnPath != "" {
+		// not a header: it becomes the envelope sender
+		email.SetReturnPath(msg.ReturnPath)
+	}
+	if msg.ListUnsubscribe != "" {
+		email.SetListUnsubscribe(msg.ListUnsubscribe)
+	}
+
+	switch {
+	case msg.Text != "" && msg.Content != "":
+		email.SetBody(mail.TextPlain, msg.Text)
+		email.AddAlternative(mail.TextHTML, msg.Content)
+	case msg.Text != "":
+		email.SetBody(mail.TextPlain, msg.Text)
+	default:
+		email.SetBody(mail.TextHTML, msg.Content)
+	}
+
+	for _, a := range msg.Attachments {
+		email.Attach(&mail.File{Name: a.Filename, MimeType: a.ContentType, Data: a.Data})
+	}
+	if email.Error != nil {
+		return email, email.Error
+	}
+
+	if dkim != nil {
+		err := dkim.sign(email, msg)
+		if err != nil {
+			return email, err
+		}
+	}
+	return email, nil
+}
+
+// messageText returns an email as it is sent, signed if it has been
+func messageText(email *mail.Email) string {
+	if email.DkimMsg != "" {
+		return email.DkimMsg
+	}
+	return email.GetMessage()
+}
@@ -0,0 +1,191 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testMessage = models.MailData{
+	To:      "john@smith.com",
+	From:    "me@here.com",
+	Subject: "Reservation Confirmation",
+	Content: "<strong>This is to confirm your reservation</strong>",
+	Text:    "This is to confirm your reservation",
+}
+
+// readMessage parses an email, and decodes its bodies and attachments by content type
+func readMessage(t *testing.T, data string) (mail.Header, map[string]string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := make(map[string]string)
+	readPart(t, textproto.MIMEHeader(msg.Header), msg.Body, bodies)
+	return msg.Header, bodies
+}
+
+// readPart decodes a part of an email into bodies, going into the parts of multipart ones
+func readPart(t *testing.T, header textproto.MIMEHeader, body io.Reader, bodies map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, body)
+		case "quoted-printable":
+			body = quotedprintable.NewReader(body)
+		}
+		b, _ := io.ReadAll(body)
+		bodies[mediaType] = string(b)
+		return
+	}
+
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		// multipart decodes quoted-printable parts itself, and removes their Content-Transfer-Encoding
+		readPart(t, part.Header, part, bodies)
+	}
+}
+
+// checkMessage fails the test unless data is testMessage, with its text and html bodies
+func checkMessage(t *testing.T, data string) {
+	header, bodies := readMessage(t, data)
+	if header.Get("To") != "<john@smith.com>" || header.Get("From") != "<me@here.com>" ||
+		header.Get("Subject") != "Reservation Confirmation" {
+		t.Errorf("wrong headers: %v", header)
+	}
+	if bodies["text/plain"] != testMessage.Text || bodies["text/html"] != testMessage.Content {
+		t.Errorf("wrong bodies: %v", bodies)
+	}
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name      string
+		cfg       Config
+		expectErr bool
+	}{
+		{"smtp", Config{Transport: TransportSMTP, Host: "localhost", Port: 1025}, false},
+		{"smtp with starttls", Config{Transport: TransportSMTP, Host: "localhost", Port: 587, Encryption: EncryptionSTARTTLS}, false},
+		{"smtp with tls", Config{Transport: TransportSMTP, Host: "localhost", Port: 465, Encryption: EncryptionTLS}, false},
+		{"smtp with unknown encryption", Config{Transport: TransportSMTP, Host: "localhost", Port: 25, Encryption: "ssl3"}, true},
+		{"smtp without host", Config{Transport: TransportSMTP, Port: 25}, true},
+		{"file", Config{Transport: TransportFile, Dir: t.TempDir()}, false},
+		{"file without dir", Config{Transport: TransportFile}, true},
+		{"memory", Config{Transport: TransportMemory}, false},
+		{"unknown", Config{Transport: "pigeon"}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.cfg)
+		if (err != nil) != e.expectErr {
+			t.Errorf("%s: expected error to be %t, got %v", e.name, e.expectErr, err)
+		}
+	}
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 messages in new, got %d", len(files))
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("expected tmp to be empty, got %d files", len(tmp))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(data))
+
+}
+
+func TestBuildEmail(t *testing.T) {
+	var tests = []struct {
+		name     string
+		content  string
+		text     string
+		expected []string
+	}{
+		{"text and html", "<p>Hi</p>", "Hi", []string{"text/plain", "text/html"}},
+		{"text only", "", "Hi", []string{"text/plain"}},
+		{"html only", "<p>Hi</p>", "", []string{"text/html"}},
+		{"with attachment", "<p>Hi</p>", "Hi", []string{"text/plain", "text/html", "text/calendar"}},
+	}
+
+	for _, e := range tests {
+		msg := testMessage
+		msg.Content = e.content
+		msg.Text = e.text
+		if e.name == "with attachment" {
+			msg.Attachments = []models.MailAttachment{
+				{Filename: "invite.ics", ContentType: "text/calendar; charset=utf-8; method=REQUEST", Data: []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")},
+			}
+		}
+
+		email, err := buildEmail(msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, bodies := readMessage(t, email.GetMessage())
+		if len(bodies) != len(e.expected) {
+			t.Errorf("%s: expected %d bodies, got %v", e.name, len(e.expected), bodies)
+		}
+		for _, x := range e.expected {
+			if _, ok := bodies[x]; !ok {
+				t.Errorf("%s: expected a %s body", e.name, x)
+			}
+		}
+		if cal, ok := bodies["text/calendar"]; ok && cal != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+			t.Errorf("%s: attachment was not kept as it was: %q", e.name, cal)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	r.Send(testMessage)
+
+	got := r.Messages()
+	if len(got) != 1 || !reflect.DeepEqual(got[0], testMessage) {
+		t.Errorf("wrong messages recorded: %+v", got)
+	}
+
+	r.Reset()
+	if len(r.Messages()) != 0 {
+		t.Error("expected no messages after a reset")
+	}
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"github.com/jjang65/booking-web-app/internal/models"
+	"sync"
+)
+
+// Recorder keeps the messages it is given instead of sending them, so tests can look at them
+type Recorder struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+// NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send records a message
+func (r *Recorder) Send(msg models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// Messages returns the messages recorded so far, oldest first
+func (r *Recorder) Messages() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.MailData(nil), r.messages...)
+}
+
+// Reset forgets the messages recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
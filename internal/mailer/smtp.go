@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"time"
+)
+
+// SMTP encryption settings
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	// EncryptionTLS connects over TLS from the start, usually on port 465
+	EncryptionTLS = "tls"
+)
+
+// smtpTimeout is how long we wait to connect to the server, and then to send a message
+const smtpTimeout = 10 * time.Second
+
+// SMTPMailer sends messages through an SMTP server, connecting once per message
+type SMTPMailer struct {
+	server *mail.SMTPServer
+	dkim   *dkimSigner
+}
+
+// NewSMTPMailer returns a mailer for the SMTP server in cfg
+func NewSMTPMailer(cfg Config) (*SMTPMailer, error) {
+	if cfg.Host == "" || cfg.Port == 0 {
+		return nil, fmt.Errorf("smtp host and port are required")
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = cfg.Host
+	server.Port = cfg.Port
+	server.Username = cfg.Username
+	server.Password = cfg.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = smtpTimeout
+	server.SendTimeout = smtpTimeout
+
+	switch cfg.Encryption {
+	case "", EncryptionNone:
+		server.Encryption = mail.EncryptionNone
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionSTARTTLS
+	case EncryptionTLS:
+		server.Encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, fmt.Errorf("unknown smtp encryption %q", cfg.Encryption)
+	}
+
+	dkim, err := newDKIMSigner(cfg.DKIM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPMailer{server: server, dkim: dkim}, nil
+}
+
+// Send delivers a message to the SMTP server; bounces go to the message's return path, if it has one
+func (s *SMTPMailer) Send(msg models.MailData) error {
+	email, err := buildEmail(msg, s.dkim)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.server.Connect()
+	if err != nil {
+		return err
+	}
+	return email.SendEnvelopeFrom(email.GetFrom(), client)
+}
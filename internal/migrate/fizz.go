@@ -0,0 +1,562 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Translate turns a fizz migration into Postgres statements, the same ones soda runs for it. Only the
+// part of fizz our migrations use is supported; anything else is an error, rather than a schema that
+// differs from the one soda makes.
+func Translate(fizz string) ([]string, error) {
+	p := &parser{src: fizz}
+	calls, err := p.calls()
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+	for _, c := range calls {
+		s, err := translateCall(c)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", c.line, c.name, err)
+		}
+		statements = append(statements, s...)
+	}
+	return statements, nil
+}
+
+// call is a fizz function call, with the calls in its block, like the columns of create_table
+type call struct {
+	name  string
+	args  []interface{}
+	block []call
+	line  int
+}
+
+// options are the options of a fizz call, like {"default": ""}
+type options map[string]interface{}
+
+func translateCall(c call) ([]string, error) {
+	switch c.name {
+	case "sql":
+		var query string
+		if err := c.scan(&query); err != nil {
+			return nil, err
+		}
+		return []string{query}, nil
+
+	case "create_table":
+		var table string
+		if err := c.scan(&table); err != nil {
+			return nil, err
+		}
+		return createTable(table, c.block)
+
+	case "drop_table":
+		var table string
+		if err := c.scan(&table); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("DROP TABLE %s", quote(table))}, nil
+
+	case "add_column":
+		var table, column, colType string
+		var opts options
+		if err := c.scan(&table, &column, &colType, &opts); err != nil {
+			return nil, err
+		}
+		def, err := columnDef(column, colType, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quote(table), def)}, nil
+
+	case "drop_column":
+		var table, column string
+		if err := c.scan(&table, &column); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", quote(table), quote(column))}, nil
+
+	case "change_column":
+		var table, column, colType string
+		var opts options
+		if err := c.scan(&table, &column, &colType, &opts); err != nil {
+			return nil, err
+		}
+		return changeColumn(table, column, colType, opts)
+
+	case "add_index":
+		var table string
+		var columns interface{}
+		var opts options
+		if err := c.scan(&table, &columns, &opts); err != nil {
+			return nil, err
+		}
+		return addIndex(table, columns, opts)
+
+	case "drop_index":
+		var table, name string
+		if err := c.scan(&table, &name); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("DROP INDEX IF EXISTS %s", quote(name))}, nil
+
+	case "add_foreign_key":
+		var table, column string
+		var refs, opts options
+		if err := c.scan(&table, &column, &refs, &opts); err != nil {
+			return nil, err
+		}
+		return addForeignKey(table, column, refs, opts)
+
+	case "drop_foreign_key":
+		var table, name string
+		var opts options
+		if err := c.scan(&table, &name, &opts); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", quote(table), quote(name))}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported fizz function")
+}
+
+// createTable creates table with the t.Column calls of block, and the created_at and updated_at
+// columns fizz adds to every table
+func createTable(table string, block []call) ([]string, error) {
+	var columns []string
+	for _, c := range block {
+		if c.name != "t.Column" {
+			return nil, fmt.Errorf("%s is not supported in create_table", c.name)
+		}
+
+		var name, colType string
+		var opts options
+		if err := c.scan(&name, &colType, &opts); err != nil {
+			return nil, err
+		}
+
+		if opts["primary"] == true {
+			if colType != "integer" {
+				return nil, fmt.Errorf("column %s: only integer primary keys are supported", name)
+			}
+			columns = append(columns, quote(name)+" SERIAL PRIMARY KEY")
+			continue
+		}
+
+		def, err := columnDef(name, colType, opts)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		columns = append(columns, def)
+	}
+	columns = append(columns, `"created_at" timestamp NOT NULL`, `"updated_at" timestamp NOT NULL`)
+
+	return []string{fmt.Sprintf("CREATE TABLE %s (\n%s\n)", quote(table), strings.Join(columns, ",\n"))}, nil
+}
+
+// columnDef is the definition of a column; columns are NOT NULL unless their options say "null": true
+func columnDef(name, colType string, opts options) (string, error) {
+	sqlType, err := columnType(colType, opts)
+	if err != nil {
+		return "", err
+	}
+
+	def := quote(name) + " " + sqlType
+	if opts["null"] != true {
+		def += " NOT NULL"
+	}
+	if d, ok := opts["default"]; ok {
+		def += " DEFAULT " + literal(d)
+	}
+	return def, nil
+}
+
+func changeColumn(table, column, colType string, opts options) ([]string, error) {
+	sqlType, err := columnType(colType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []string{fmt.Sprintf("ALTER COLUMN %s TYPE %s", quote(column), sqlType)}
+	if opts["null"] == true {
+		changes = append(changes, fmt.Sprintf("ALTER COLUMN %s DROP NOT NULL", quote(column)))
+	} else {
+		changes = append(changes, fmt.Sprintf("ALTER COLUMN %s SET NOT NULL", quote(column)))
+	}
+	if d, ok := opts["default"]; ok {
+		changes = append(changes, fmt.Sprintf("ALTER COLUMN %s SET DEFAULT %s", quote(column), literal(d)))
+	} else {
+		changes = append(changes, fmt.Sprintf("ALTER COLUMN %s DROP DEFAULT", quote(column)))
+	}
+
+	return []string{fmt.Sprintf("ALTER TABLE %s %s", quote(table), strings.Join(changes, ", "))}, nil
+}
+
+// columnType is the Postgres type of a fizz column type
+func columnType(colType string, opts options) (string, error) {
+	switch colType {
+	case "string":
+		size := 255
+		if s, ok := opts["size"]; ok {
+			n, ok := s.(int)
+			if !ok {
+				return "", fmt.Errorf("size %v is not a number", s)
+			}
+			size = n
+		}
+		return fmt.Sprintf("VARCHAR (%d)", size), nil
+	case "text", "integer", "date", "bool", "timestamp":
+		return colType, nil
+	case "blob":
+		return "BYTEA", nil
+	}
+	return "", fmt.Errorf("unsupported column type %q", colType)
+}
+
+// addIndex indexes columns, which is a column name or a list of them, by default named after them
+func addIndex(table string, columns interface{}, opts options) ([]string, error) {
+	var names []string
+	switch c := columns.(type) {
+	case string:
+		names = []string{c}
+	case []interface{}:
+		for _, v := range c {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("index column %v is not a string", v)
+			}
+			names = append(names, name)
+		}
+	default:
+		return nil, fmt.Errorf("index columns %v are not a string or a list", columns)
+	}
+
+	index := fmt.Sprintf("%s_%s_idx", table, strings.Join(names, "_"))
+	if n, ok := opts["name"].(string); ok {
+		index = n
+	}
+
+	unique := ""
+	if opts["unique"] == true {
+		unique = "UNIQUE "
+	}
+
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quote(n)
+	}
+	return []string{fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+		unique, quote(index), quote(table), strings.Join(quoted, ", "))}, nil
+}
+
+// addForeignKey adds a key from column to refs, like {"rooms": ["id"]}, named table_reftable_refcolumn_fk
+func addForeignKey(table, column string, refs, opts options) ([]string, error) {
+	if len(refs) != 1 {
+		return nil, fmt.Errorf("expected one referenced table, got %d", len(refs))
+	}
+
+	var refTable string
+	var refColumns []interface{}
+	for t, cols := range refs {
+		refTable = t
+		refColumns, _ = cols.([]interface{})
+	}
+	if len(refColumns) != 1 {
+		return nil, fmt.Errorf("expected one referenced column of %s", refTable)
+	}
+	refColumn, ok := refColumns[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("referenced column %v is not a string", refColumns[0])
+	}
+
+	name := fmt.Sprintf("%s_%s_%s_fk", table, refTable, refColumn)
+	if n, ok := opts["name"].(string); ok {
+		name = n
+	}
+
+	s := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		quote(table), quote(name), quote(column), quote(refTable), quote(refColumn))
+	for _, action := range []string{"on_delete", "on_update"} {
+		if v, ok := opts[action].(string); ok {
+			s += fmt.Sprintf(" %s %s", strings.ToUpper(strings.Replace(action, "_", " ", 1)), v)
+		}
+	}
+	return []string{s}, nil
+}
+
+// quote quotes an identifier
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// literal is a default value as a string literal, which Postgres casts to the column's type, as soda does
+func literal(v interface{}) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
+
+// scan assigns the arguments of c to dest, which point to strings, options or interface{} values.
+// Trailing options may be left out.
+func (c call) scan(dest ...interface{}) error {
+	if len(c.args) > len(dest) {
+		return fmt.Errorf("expected at most %d arguments, got %d", len(dest), len(c.args))
+	}
+
+	for i, d := range dest {
+		if i >= len(c.args) {
+			if _, ok := d.(*options); ok {
+				continue
+			}
+			return fmt.Errorf("expected %d arguments, got %d", len(dest), len(c.args))
+		}
+
+		arg := c.args[i]
+		switch d := d.(type) {
+		case *string:
+			s, ok := arg.(string)
+			if !ok {
+				return fmt.Errorf("argument %d: %v is not a string", i+1, arg)
+			}
+			*d = s
+		case *options:
+			o, ok := arg.(options)
+			if !ok {
+				return fmt.Errorf("argument %d: %v is not an options map", i+1, arg)
+			}
+			*d = o
+		case *interface{}:
+			*d = arg
+		}
+	}
+	return nil
+}
+
+// parser reads fizz: calls like name(args) { calls }, whose args are strings, numbers, booleans,
+// lists like ["a", "b"] and maps like {"key": value, bare_key: value}
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) calls() ([]call, error) {
+	var calls []call
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.peek() == '}' {
+			return calls, nil
+		}
+
+		c, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, c)
+	}
+}
+
+func (p *parser) call() (call, error) {
+	c := call{line: p.line + 1}
+	c.name = p.ident()
+	if c.name == "" {
+		return c, p.errorf("expected a function name")
+	}
+
+	if err := p.expect('('); err != nil {
+		return c, err
+	}
+	for {
+		p.skipSpace()
+		if p.peek() == ')' {
+			p.pos++
+			break
+		}
+
+		v, err := p.value()
+		if err != nil {
+			return c, err
+		}
+		c.args = append(c.args, v)
+
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+		} else if p.peek() != ')' {
+			return c, p.errorf("expected , or )")
+		}
+	}
+
+	p.skipSpace()
+	if p.peek() == '{' {
+		p.pos++
+		block, err := p.calls()
+		if err != nil {
+			return c, err
+		}
+		if err := p.expect('}'); err != nil {
+			return c, err
+		}
+		c.block = block
+	}
+	return c, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	p.skipSpace()
+	switch ch := p.peek(); {
+	case ch == '"':
+		return p.str()
+
+	case ch == '{':
+		p.pos++
+		m := options{}
+		for {
+			p.skipSpace()
+			if p.peek() == '}' {
+				p.pos++
+				return m, nil
+			}
+
+			var key string
+			if p.peek() == '"' {
+				s, err := p.str()
+				if err != nil {
+					return nil, err
+				}
+				key = s
+			} else if key = p.ident(); key == "" {
+				return nil, p.errorf("expected a key")
+			}
+
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+
+			p.skipSpace()
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != '}' {
+				return nil, p.errorf("expected , or }")
+			}
+		}
+
+	case ch == '[':
+		p.pos++
+		var list []interface{}
+		for {
+			p.skipSpace()
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+
+			p.skipSpace()
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return nil, p.errorf("expected , or ]")
+			}
+		}
+
+	case ch == '-' || unicode.IsDigit(rune(ch)):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && unicode.IsDigit(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		return strconv.Atoi(p.src[start:p.pos])
+	}
+
+	switch word := p.ident(); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("expected a value")
+	default:
+		return nil, p.errorf("unexpected %s", word)
+	}
+}
+
+// str reads a double-quoted string, with Go escapes
+func (p *parser) str() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	s, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		return "", p.errorf("bad string %s", p.src[start:p.pos])
+	}
+	return s, nil
+}
+
+// ident reads a name like create_table or t.Column
+func (p *parser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		ch := rune(p.src[p.pos])
+		if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '_' && ch != '.' {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) expect(ch byte) error {
+	p.skipSpace()
+	if p.peek() != ch {
+		return p.errorf("expected %c", ch)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		if p.src[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line+1, fmt.Sprintf(format, args...))
+}
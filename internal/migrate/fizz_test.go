@@ -0,0 +1,168 @@
+package migrate
+
+import (
+	"github.com/jjang65/booking-web-app/migrations"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		fizz     string
+		expected []string
+	}{
+		{
+			name: "create table",
+			fizz: `create_table("users") {
+  t.Column("id", "integer", {primary: true})
+  t.Column("first_name", "string", {"default": ""})
+  t.Column("password", "string", {"size": 60})
+  t.Column("user_id", "integer", {"null": true})
+  t.Column("data", "blob", {})
+}`,
+			expected: []string{`CREATE TABLE "users" (
+"id" SERIAL PRIMARY KEY,
+"first_name" VARCHAR (255) NOT NULL DEFAULT '',
+"password" VARCHAR (60) NOT NULL,
+"user_id" integer,
+"data" BYTEA NOT NULL,
+"created_at" timestamp NOT NULL,
+"updated_at" timestamp NOT NULL
+)`},
+		},
+		{
+			name:     "drop table",
+			fizz:     `drop_table("rooms")`,
+			expected: []string{`DROP TABLE "rooms"`},
+		},
+		{
+			name: "columns",
+			fizz: `add_column("rooms", "active", "bool", {"default": true})
+add_column("rooms", "description", "text", {"default": "It's lovely"})
+add_column("reservations", "cancelled_at", "timestamp", {"null": true})
+drop_column("reservations", "processed")`,
+			expected: []string{
+				`ALTER TABLE "rooms" ADD COLUMN "active" bool NOT NULL DEFAULT 'true'`,
+				`ALTER TABLE "rooms" ADD COLUMN "description" text NOT NULL DEFAULT 'It''s lovely'`,
+				`ALTER TABLE "reservations" ADD COLUMN "cancelled_at" timestamp`,
+				`ALTER TABLE "reservations" DROP COLUMN "processed"`,
+			},
+		},
+		{
+			name: "change column",
+			fizz: `change_column("room_restrictions", "reservation_id", "integer", {"null": true})
+change_column("reservations", "code", "string", {})`,
+			expected: []string{
+				`ALTER TABLE "room_restrictions" ALTER COLUMN "reservation_id" TYPE integer, ` +
+					`ALTER COLUMN "reservation_id" DROP NOT NULL, ALTER COLUMN "reservation_id" DROP DEFAULT`,
+				`ALTER TABLE "reservations" ALTER COLUMN "code" TYPE VARCHAR (255), ` +
+					`ALTER COLUMN "code" SET NOT NULL, ALTER COLUMN "code" DROP DEFAULT`,
+			},
+		},
+		{
+			name: "indexes",
+			fizz: `add_index("users", "email", {"unique": true})
+add_index("room_restrictions", ["start_date", "end_date"], {})
+add_index("rooms", "slug")
+drop_index("users", "users_email_idx")`,
+			expected: []string{
+				`CREATE UNIQUE INDEX "users_email_idx" ON "users" ("email")`,
+				`CREATE INDEX "room_restrictions_start_date_end_date_idx" ON "room_restrictions" ("start_date", "end_date")`,
+				`CREATE INDEX "rooms_slug_idx" ON "rooms" ("slug")`,
+				`DROP INDEX IF EXISTS "users_email_idx"`,
+			},
+		},
+		{
+			name: "foreign keys",
+			fizz: `add_foreign_key("payments", "reservation_id", {"reservations": ["id"]}, {
+    "on_delete": "set null",
+    "on_update": "cascade",
+})
+drop_foreign_key("reservations", "reservations_rooms_id_fk", {})`,
+			expected: []string{
+				`ALTER TABLE "payments" ADD CONSTRAINT "payments_reservations_id_fk" FOREIGN KEY ("reservation_id") ` +
+					`REFERENCES "reservations" ("id") ON DELETE set null ON UPDATE cascade`,
+				`ALTER TABLE "reservations" DROP CONSTRAINT "reservations_rooms_id_fk"`,
+			},
+		},
+		{
+			name:     "sql",
+			fizz:     `sql("UPDATE rooms SET slug = replace(room_name, '''', '')")`,
+			expected: []string{`UPDATE rooms SET slug = replace(room_name, '''', '')`},
+		},
+		{
+			name: "empty",
+			fizz: "\n",
+		},
+	}
+
+	for _, e := range tests {
+		got, err := Translate(e.fizz)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", e.name, strings.Join(e.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestTranslate_Errors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		fizz     string
+		expected string
+	}{
+		{"unknown function", `rename_table("a", "b")`, `line 1: rename_table: unsupported fizz function`},
+		{"unknown type", "\nadd_column(\"a\", \"b\", \"money\", {})", `line 2: add_column: unsupported column type "money"`},
+		{"missing argument", `drop_column("a")`, "expected 2 arguments"},
+		{"wrong argument", `drop_table({})`, "is not a string"},
+		{"unknown in table", `create_table("a") { t.Timestamps() }`, "t.Timestamps is not supported"},
+		{"unterminated string", `sql("SELECT 1)`, "unterminated string"},
+		{"unclosed call", `drop_table("a"`, "expected , or )"},
+	}
+
+	for _, e := range tests {
+		_, err := Translate(e.fizz)
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 27 {
+		t.Errorf("expected 27 migrations, got %d", len(loaded))
+	}
+	for i := 1; i < len(loaded); i++ {
+		if loaded[i-1].Version >= loaded[i].Version {
+			t.Errorf("migrations out of order: %s before %s", loaded[i-1].Version, loaded[i].Version)
+		}
+	}
+
+	first := loaded[0]
+	if first.Version != "20220425032022" || first.Name != "create_user_table" {
+		t.Errorf("wrong first migration %s_%s", first.Version, first.Name)
+	}
+	if len(first.Up) != 1 || !strings.HasPrefix(first.Up[0], `CREATE TABLE "users"`) {
+		t.Errorf("wrong up statements %q", first.Up)
+	}
+	if !reflect.DeepEqual(first.Down, []string{"DROP TABLE users"}) {
+		t.Errorf("wrong down statements %q", first.Down)
+	}
+
+	// a down file with nothing in it is a migration that can be rolled back, doing nothing
+	for _, mig := range loaded {
+		if mig.Version == "20220426220433" && (mig.Down == nil || len(mig.Down) != 0) {
+			t.Errorf("expected an empty rollback, got %q", mig.Down)
+		}
+	}
+}
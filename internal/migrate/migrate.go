@@ -0,0 +1,307 @@
+// Package migrate applies the fizz migrations of the schema without soda
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// schemaTable records the applied versions. It is the table soda uses, so a database can be
+// migrated with either.
+const schemaTable = "schema_migration"
+
+// lockID is the key of the Postgres advisory lock that is held while migrating; any number will do,
+// as long as nothing else locks it
+const lockID = 20220425032022
+
+// fileName matches migration files like 20220425032022_create_user_table.up.fizz
+var fileName = regexp.MustCompile(`^(\d{14})_(.+)\.(up|down)\.fizz$`)
+
+// Migration is one version of the schema, and the statements to go to it and back
+type Migration struct {
+	Version string
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status is whether a migration has been applied. A version that has been applied but has no
+// migration file has no Name.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations to a database. Only one migrator changes the schema at a time, even in
+// different processes; the others wait for it.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Log gets a line for every migration applied or rolled back
+	Log *log.Logger
+}
+
+// New returns a migrator for the fizz migrations in fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations, Log: log.New(io.Discard, "", 0)}, nil
+}
+
+// Load reads and translates the fizz migrations in fsys, in order of version. Every migration must
+// have an up and a down file, though the down file may be empty.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.fizz")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, f := range files {
+		parts := fileName.FindStringSubmatch(path.Base(f))
+		if parts == nil {
+			return nil, fmt.Errorf("%s: not a migration file name", f)
+		}
+
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		statements, err := Translate(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		mig, ok := byVersion[parts[1]]
+		if !ok {
+			mig = &Migration{Version: parts[1], Name: parts[2]}
+			byVersion[parts[1]] = mig
+		}
+		if parts[3] == "up" {
+			mig.Up = statements
+		} else {
+			// an empty down file is a migration with nothing to undo, so it isn't left nil
+			mig.Down = append([]string{}, statements...)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == nil || mig.Down == nil {
+			return nil, fmt.Errorf("migration %s_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies all the migrations that haven't been applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[string]bool) error {
+		for _, mig := range m.Migrations {
+			if !applied[mig.Version] {
+				if err := m.run(ctx, conn, mig, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last steps migrations that were applied
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[string]bool) error {
+		for _, v := range newestFirst(applied) {
+			if steps <= 0 {
+				break
+			}
+			if err := m.rollback(ctx, conn, v); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// To migrates the schema to version, rolling back the migrations after it and applying those up to it.
+// Version "0" rolls back all of them.
+func (m *Migrator) To(ctx context.Context, version string) error {
+	if version != "0" && m.find(version) == nil {
+		return fmt.Errorf("there is no migration %s", version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn, applied map[string]bool) error {
+		for _, v := range newestFirst(applied) {
+			if v > version {
+				if err := m.rollback(ctx, conn, v); err != nil {
+					return err
+				}
+			}
+		}
+		for _, mig := range m.Migrations {
+			if mig.Version <= version && !applied[mig.Version] {
+				if err := m.run(ctx, conn, mig, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied := make(map[string]bool)
+	var exists bool
+	err = conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", schemaTable).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		applied, err = appliedVersions(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var status []Status
+	for _, mig := range m.Migrations {
+		status = append(status, Status{Migration: mig, Applied: applied[mig.Version]})
+		delete(applied, mig.Version)
+	}
+	for v := range applied {
+		status = append(status, Status{Migration: Migration{Version: v}, Applied: true})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, nil
+}
+
+// locked runs f with the applied versions, on a connection that holds the migration lock
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[string]bool) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the lock belongs to the connection, so everything is done on it
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+schemaTable+` (version varchar(14) NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx,
+		`CREATE UNIQUE INDEX IF NOT EXISTS `+schemaTable+`_version_idx ON `+schemaTable+` (version)`)
+	if err != nil {
+		return err
+	}
+
+	// read after taking the lock, so what another migrator did while we waited is seen
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return f(conn, applied)
+}
+
+// rollback rolls back the applied version
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, version string) error {
+	mig := m.find(version)
+	if mig == nil {
+		return fmt.Errorf("migration %s has been applied, but there is no file to roll it back", version)
+	}
+	return m.run(ctx, conn, *mig, false)
+}
+
+// run applies mig, or rolls it back, and records it in one transaction
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements, record, done := mig.Up, `INSERT INTO `+schemaTable+` (version) VALUES ($1)`, "applied"
+	if !up {
+		statements, record, done = mig.Down, `DELETE FROM `+schemaTable+` WHERE version = $1`, "rolled back"
+	}
+
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return fmt.Errorf("migration %s_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, mig.Version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.Log.Printf("%s %s_%s", done, mig.Version, mig.Name)
+	return nil
+}
+
+func (m *Migrator) find(version string) *Migration {
+	for i := range m.Migrations {
+		if m.Migrations[i].Version == version {
+			return &m.Migrations[i]
+		}
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM `+schemaTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// newestFirst lists the applied versions, newest first
+func newestFirst(applied map[string]bool) []string {
+	versions := make([]string, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions
+}
@@ -0,0 +1,329 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePostgres is enough of a database for the migrator: an advisory lock, the schema table, and
+// transactions whose statements are recorded when they commit
+type fakePostgres struct {
+	lock chan struct{}
+
+	mu          sync.Mutex
+	tableExists bool
+	applied     map[string]bool
+	executed    []string
+	// fail is a statement that fails
+	fail string
+}
+
+func newFakePostgres() *fakePostgres {
+	return &fakePostgres{lock: make(chan struct{}, 1), applied: make(map[string]bool)}
+}
+
+func (db *fakePostgres) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{db: db}, nil
+}
+
+func (db *fakePostgres) Driver() driver.Driver {
+	return nil
+}
+
+func (db *fakePostgres) statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string{}, db.executed...)
+}
+
+type fakeConn struct {
+	db        *fakePostgres
+	holdsLock bool
+
+	// the statements and version changes of the transaction in progress
+	inTx     bool
+	executed []string
+	versions map[string]bool
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch {
+	case query == "SELECT pg_advisory_lock($1)":
+		select {
+		case c.db.lock <- struct{}{}:
+			c.holdsLock = true
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	case query == "SELECT pg_advisory_unlock($1)":
+		if c.holdsLock {
+			c.holdsLock = false
+			<-c.db.lock
+		}
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migration"):
+		c.db.mu.Lock()
+		c.db.tableExists = true
+		c.db.mu.Unlock()
+	case strings.HasPrefix(query, "CREATE UNIQUE INDEX IF NOT EXISTS schema_migration"):
+	case strings.HasPrefix(query, "INSERT INTO schema_migration"):
+		c.versions[args[0].Value.(string)] = true
+	case strings.HasPrefix(query, "DELETE FROM schema_migration"):
+		c.versions[args[0].Value.(string)] = false
+	default:
+		if !c.inTx {
+			return nil, errors.New("migration statement outside of a transaction")
+		}
+		c.db.mu.Lock()
+		fail := c.db.fail
+		c.db.mu.Unlock()
+		if query == fail {
+			return nil, errors.New("syntax error")
+		}
+		// give a migrator running at the same time a chance to get in the way
+		time.Sleep(time.Millisecond)
+		c.executed = append(c.executed, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	switch query {
+	case "SELECT to_regclass($1) IS NOT NULL":
+		return &fakeRows{values: [][]driver.Value{{c.db.tableExists}}}, nil
+	case "SELECT version FROM schema_migration":
+		rows := &fakeRows{}
+		for v := range c.db.applied {
+			rows.values = append(rows.values, []driver.Value{v})
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx, c.executed, c.versions = true, nil, make(map[string]bool)
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.executed = append(c.db.executed, c.executed...)
+	for v, applied := range c.versions {
+		if applied {
+			c.db.applied[v] = true
+		} else {
+			delete(c.db.applied, v)
+		}
+	}
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"value"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var testMigrations = []Migration{
+	{Version: "20220101000000", Name: "create_rooms", Up: []string{"CREATE rooms"}, Down: []string{"DROP rooms"}},
+	{Version: "20220102000000", Name: "add_slug", Up: []string{"ADD slug", "FILL slug"}, Down: []string{"DROP slug"}},
+	{Version: "20220103000000", Name: "nothing_to_undo", Up: []string{"FIX data"}, Down: []string{}},
+}
+
+func newTestMigrator(db *fakePostgres) *Migrator {
+	return &Migrator{
+		DB:         sql.OpenDB(db),
+		Migrations: testMigrations,
+		Log:        log.New(io.Discard, "", 0),
+	}
+}
+
+func appliedStatus(t *testing.T, m *Migrator) []bool {
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []bool
+	for _, s := range status {
+		applied = append(applied, s.Applied)
+	}
+	return applied
+}
+
+func TestMigrator(t *testing.T) {
+	db := newFakePostgres()
+	m := newTestMigrator(db)
+	ctx := context.Background()
+
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{false, false, false}) {
+		t.Errorf("expected nothing applied on a new database, got %v", got)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.statements(); !reflect.DeepEqual(got, []string{"CREATE rooms", "ADD slug", "FILL slug", "FIX data"}) {
+		t.Errorf("wrong statements for up: %q", got)
+	}
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{true, true, true}) {
+		t.Errorf("expected all applied, got %v", got)
+	}
+
+	// up again does nothing
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(db.statements()); got != 4 {
+		t.Errorf("expected nothing to run a second time, got %d statements", got)
+	}
+
+	if err := m.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.statements()[4:]; !reflect.DeepEqual(got, []string{"DROP slug"}) {
+		t.Errorf("wrong statements for down: %q", got)
+	}
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{true, false, false}) {
+		t.Errorf("expected only the first applied, got %v", got)
+	}
+
+	if err := m.To(ctx, "20220102000000"); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{true, true, false}) {
+		t.Errorf("expected the first two applied, got %v", got)
+	}
+
+	if err := m.To(ctx, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{false, false, false}) {
+		t.Errorf("expected everything rolled back, got %v", got)
+	}
+
+	if err := m.To(ctx, "20229999999999"); err == nil {
+		t.Error("expected an error for a version with no migration")
+	}
+}
+
+func TestMigrator_Failure(t *testing.T) {
+	db := newFakePostgres()
+	db.fail = "FILL slug"
+	m := newTestMigrator(db)
+
+	err := m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "20220102000000_add_slug") {
+		t.Fatalf("expected the failing migration to be named, got %v", err)
+	}
+
+	// the failed migration is rolled back as a whole, and the ones after it aren't run
+	if got := db.statements(); !reflect.DeepEqual(got, []string{"CREATE rooms"}) {
+		t.Errorf("expected only the first migration to be run, got %q", got)
+	}
+	if got := appliedStatus(t, m); !reflect.DeepEqual(got, []bool{true, false, false}) {
+		t.Errorf("expected only the first applied, got %v", got)
+	}
+}
+
+func TestMigrator_UnknownVersion(t *testing.T) {
+	db := newFakePostgres()
+	db.tableExists = true
+	db.applied["20211231000000"] = true
+	m := newTestMigrator(db)
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 4 || status[0].Version != "20211231000000" || !status[0].Applied || status[0].Name != "" {
+		t.Errorf("expected the version with no file to be listed first, got %+v", status)
+	}
+
+	if err := m.To(context.Background(), "0"); err == nil || !strings.Contains(err.Error(), "no file") {
+		t.Errorf("expected an error rolling back a version with no file, got %v", err)
+	}
+}
+
+func TestMigrator_Concurrent(t *testing.T) {
+	db := newFakePostgres()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- newTestMigrator(db).Up(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := db.statements(); !reflect.DeepEqual(got, []string{"CREATE rooms", "ADD slug", "FILL slug", "FIX data"}) {
+		t.Errorf("expected every migration to run once, got %q", got)
+	}
+}
+
+func TestMigrator_LockWait(t *testing.T) {
+	db := newFakePostgres()
+	db.lock <- struct{}{} // another migrator is running
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := newTestMigrator(db).Up(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected to give up waiting for the lock, got %v", err)
+	}
+	if len(db.statements()) != 0 {
+		t.Error("expected nothing to run without the lock")
+	}
+}
@@ -12,16 +12,46 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	Active      bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// Access levels for staff users; each level can do everything the levels below it can
+const (
+	AccessLevelViewer    = 1
+	AccessLevelFrontDesk = 2
+	AccessLevelOwner     = 3
+)
+
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            int
+	RoomName      string
+	Slug          string
+	Description   string
+	Image         string
+	Active        bool
+	ICalToken     string
+	ICalImportURL string
+	NightlyRate   int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RoomRate overrides a room's nightly rate from StartDate up to and including the night of EndDate.
+// Weekdays limits it to some days of the week, as a bit mask with Sunday as bit 0; zero means every day.
+// Rates are in cents.
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	Weekdays    int
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 // Restriction is the Restriction model
@@ -34,7 +64,9 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
-	ID        int
+	ID int
+	// Code is the confirmation code guests use to look up their reservation, such as BK-7F3K9Q
+	Code      string
 	FirstName string
 	LastName  string
 	Email     string
@@ -42,12 +74,43 @@ type Reservation struct {
 	StartDate time.Time
 	EndDate   time.Time
 	RoomID    int
+	Total     int
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Processed int
+	Status    string
 	Room      Room
 }
 
+// Reservation statuses; the lifecycle package says which changes are allowed
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked_in"
+	ReservationCheckedOut = "checked_out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no_show"
+)
+
+// ReservationStatusChange records who moved a reservation from one status to another, and when
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	// UserID is 0 when the guest made the change
+	UserID    int
+	User      User
+	CreatedAt time.Time
+}
+
+// Restriction IDs, matching the rows in the restrictions table
+const (
+	RestrictionOwnerBlock  = 1
+	RestrictionReservation = 2
+	// RestrictionExternal is a booking imported from another platform's calendar
+	RestrictionExternal = 3
+)
+
 // RoomRestriction is the roomRestriction model
 type RoomRestriction struct {
 	ID            int
@@ -56,6 +119,7 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	ExternalUID   string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -63,11 +127,84 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
-// MailData holds an email message
+// Payment is a card payment for a reservation; amounts are in cents
+type Payment struct {
+	ID            int
+	ReservationID int
+	Amount        int
+	Refunded      int
+	// RefundDue is what is still to be refunded, after the gateway failed to refund it
+	RefundDue       int
+	Status          string
+	AuthorizationID string
+	Error           string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Payment statuses
+const (
+	PaymentPending    = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentDeclined   = "declined"
+	PaymentFailed     = "failed"
+	PaymentRefunded   = "refunded"
+)
+
+// MailData holds an email message; Content is its HTML body, and Text its plain text body.
+// ReplyTo, ReturnPath and ListUnsubscribe are left out of the email when empty.
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To      string
+	From    string
+	Subject string
+	Content string
+	Text    string
+	ReplyTo string
+	// ReturnPath is where bounces go
+	ReturnPath string
+	// ListUnsubscribe is the List-Unsubscribe header, such as <mailto:unsubscribe@here.com>
+	ListUnsubscribe string
+	Attachments     []MailAttachment
+}
+
+// MailAttachment is a file attached to an email message
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// MailMessage is an email waiting in the outbox, or already sent from it
+type MailMessage struct {
+	ID            int
+	ReservationID int
+	MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Failures      []MailFailure
+}
+
+// Mail statuses
+const (
+	// MailHeld messages wait for their reservation to be paid for
+	MailHeld    = "held"
+	MailPending = "pending"
+	MailSending = "sending"
+	MailSent    = "sent"
+	// MailDead messages gave up after too many failed attempts, and are only sent again by hand
+	MailDead = "dead"
+)
+
+// MailFailure records why an attempt to send a message failed
+type MailFailure struct {
+	ID        int
+	MessageID int
+	Attempt   int
+	Error     string
+	CreatedAt time.Time
 }
@@ -0,0 +1,151 @@
+package outbox
+
+import (
+	"context"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// Store is the part of the database the outbox works with
+type Store interface {
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.MailMessage, error)
+	MarkMailSent(ctx context.Context, id int) error
+	MarkMailFailed(ctx context.Context, msg models.MailMessage, reason string) error
+}
+
+// SendFunc hands a message to the mail server
+type SendFunc func(msg models.MailData) error
+
+// Dispatcher delivers the mail in the outbox with a pool of workers. A message that can't be
+// sent is tried again later, waiting twice as long after every failure, until it has had
+// MaxAttempts attempts; then it is dead, and only goes out again if someone resends it.
+type Dispatcher struct {
+	Store    Store
+	Send     SendFunc
+	ErrorLog *log.Logger
+
+	// Workers is the number of messages being sent at once
+	Workers int
+	// BatchSize is the number of messages a worker claims at a time
+	BatchSize int
+	// PollInterval is how long an idle worker waits before looking for mail again
+	PollInterval time.Duration
+	// Lease is how long a worker has to send the messages it claimed, before others may take them
+	Lease time.Duration
+	// MaxAttempts is the number of attempts before a message is given up on
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, and MaxDelay the longest wait between attempts
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// New returns a dispatcher with the default settings
+func New(store Store, send SendFunc, errorLog *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Send:         send,
+		ErrorLog:     errorLog,
+		Workers:      4,
+		BatchSize:    10,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+		MaxAttempts:  10,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     4 * time.Hour,
+	}
+}
+
+// Run runs the workers until ctx is done, and returns once they have finished the messages they
+// claimed. A batch is finished even after ctx is done, so no message is left claimed but unsent;
+// the database calls made for it give up only when work is done.
+func (d *Dispatcher) Run(ctx, work context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n, err := d.Deliver(work)
+				if err != nil {
+					d.ErrorLog.Println("outbox:", err)
+				}
+				if n == 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(d.PollInterval):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Flush sends the mail that is due, until there is none left or ctx is done
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	for {
+		n, err := d.Deliver(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// Deliver claims a batch of messages that are due and sends them, and returns how many it claimed
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	messages, err := d.Store.ClaimMail(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		err := d.Send(msg.MailData)
+		if err == nil {
+			err = d.Store.MarkMailSent(ctx, msg.ID)
+			if err != nil {
+				// the lease runs out and the message is sent again, which beats not sending it
+				d.ErrorLog.Printf("outbox: message %d was sent but can't be marked as sent: %s", msg.ID, err)
+			}
+			continue
+		}
+
+		d.fail(ctx, msg, err)
+	}
+	return len(messages), nil
+}
+
+// fail schedules the next attempt at a message that couldn't be sent, or gives up on it
+func (d *Dispatcher) fail(ctx context.Context, msg models.MailMessage, sendErr error) {
+	if msg.Attempts >= d.MaxAttempts {
+		msg.Status = models.MailDead
+		d.ErrorLog.Printf("outbox: giving up on message %d to %s after %d attempts: %s",
+			msg.ID, msg.To, msg.Attempts, sendErr)
+	} else {
+		msg.Status = models.MailPending
+		msg.NextAttemptAt = time.Now().Add(d.Backoff(msg.Attempts))
+		d.ErrorLog.Printf("outbox: attempt %d at message %d to %s failed: %s",
+			msg.Attempts, msg.ID, msg.To, sendErr)
+	}
+
+	err := d.Store.MarkMailFailed(ctx, msg, sendErr.Error())
+	if err != nil {
+		d.ErrorLog.Printf("outbox: can't record the failure of message %d: %s", msg.ID, err)
+	}
+}
+
+// Backoff returns how long to wait after a message's attempts-th failed attempt
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.MaxDelay {
+			return d.MaxDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,208 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is an outbox in memory
+type memoryStore struct {
+	mu     sync.Mutex
+	due    []models.MailMessage
+	sent   []int
+	failed []models.MailMessage
+	errors []string
+}
+
+func (s *memoryStore) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.MailMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.due) < limit {
+		limit = len(s.due)
+	}
+	claimed := s.due[:limit]
+	s.due = s.due[limit:]
+	for i := range claimed {
+		claimed[i].Status = models.MailSending
+		claimed[i].Attempts++
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) MarkMailSent(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *memoryStore) MarkMailFailed(ctx context.Context, msg models.MailMessage, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed = append(s.failed, msg)
+	s.errors = append(s.errors, reason)
+	return nil
+}
+
+func newTestDispatcher(store Store, send SendFunc) *Dispatcher {
+	return New(store, send, log.New(io.Discard, "", 0))
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	store := &memoryStore{due: []models.MailMessage{
+		{ID: 1, MailData: models.MailData{To: "john@smith.com"}},
+		{ID: 2, MailData: models.MailData{To: "down@smith.com"}},
+		{ID: 3, MailData: models.MailData{To: "down@smith.com"}, Attempts: 9},
+	}}
+	d := newTestDispatcher(store, func(msg models.MailData) error {
+		if msg.To == "down@smith.com" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	start := time.Now()
+	n, err := d.Deliver(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 messages to be claimed, got %d", n)
+	}
+
+	if len(store.sent) != 1 || store.sent[0] != 1 {
+		t.Errorf("expected message 1 to be sent, got %v", store.sent)
+	}
+	if len(store.failed) != 2 {
+		t.Fatalf("expected 2 failures, got %d", len(store.failed))
+	}
+
+	retry := store.failed[0]
+	if retry.ID != 2 || retry.Status != models.MailPending || retry.Attempts != 1 {
+		t.Errorf("wrong retry: %+v", retry)
+	}
+	if retry.NextAttemptAt.Before(start.Add(d.BaseDelay)) {
+		t.Errorf("retry is due too soon: %s", retry.NextAttemptAt)
+	}
+	if store.errors[0] != "connection refused" {
+		t.Errorf("wrong reason recorded: %q", store.errors[0])
+	}
+
+	dead := store.failed[1]
+	if dead.ID != 3 || dead.Status != models.MailDead || dead.Attempts != 10 {
+		t.Errorf("expected message 3 to be given up on, got %+v", dead)
+	}
+
+	n, err = d.Deliver(context.Background())
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing left to claim, got %d, %v", n, err)
+	}
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	store := &memoryStore{due: []models.MailMessage{{ID: 1}, {ID: 2}}}
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	d := newTestDispatcher(store, func(msg models.MailData) error {
+		if msg.To == "" {
+			sending <- struct{}{}
+			<-release
+		}
+		return nil
+	})
+	d.Workers = 1
+	d.PollInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, context.Background())
+		close(done)
+	}()
+
+	// stop the dispatcher while it is sending the first message of its batch
+	<-sending
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run returned before its batch was sent")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	<-sending
+	release <- struct{}{}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after its context was done")
+	}
+	if len(store.sent) != 2 {
+		t.Errorf("expected the whole batch to be sent, got %v", store.sent)
+	}
+}
+
+func TestDispatcher_Flush(t *testing.T) {
+	store := &memoryStore{}
+	for i := 1; i <= 25; i++ {
+		store.due = append(store.due, models.MailMessage{ID: i})
+	}
+	d := newTestDispatcher(store, func(msg models.MailData) error {
+		return nil
+	})
+
+	if err := d.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.sent) != 25 || len(store.due) != 0 {
+		t.Errorf("expected all 25 messages to be sent, got %d with %d left", len(store.sent), len(store.due))
+	}
+
+	// a flush that is out of time stops
+	store.due = []models.MailMessage{{ID: 26}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.Flush(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if len(store.due) != 1 {
+		t.Error("expected nothing to be sent after the flush ran out of time")
+	}
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := newTestDispatcher(&memoryStore{}, nil)
+	d.BaseDelay = time.Minute
+	d.MaxDelay = time.Hour
+
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := d.Backoff(e.attempts); got != e.expected {
+			t.Errorf("Backoff(%d) = %s, wanted %s", e.attempts, got, e.expected)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Card tokens understood by FakeGateway
+const (
+	FakeTokenApprove = "tok_approve"
+	FakeTokenDecline = "tok_decline"
+	FakeTokenTimeout = "tok_timeout"
+	// FakeTokenCaptureFail is authorized, but the capture is declined
+	FakeTokenCaptureFail = "tok_capture_fail"
+	// FakeTokenCaptureTimeout is authorized, but the capture times out without taking the money
+	FakeTokenCaptureTimeout = "tok_capture_timeout"
+)
+
+// FakeTokens lists the tokens FakeGateway understands, with what they do, for test payment forms
+var FakeTokens = map[string]string{
+	FakeTokenApprove:        "Approve",
+	FakeTokenDecline:        "Decline",
+	FakeTokenTimeout:        "Time out",
+	FakeTokenCaptureFail:    "Fail to capture",
+	FakeTokenCaptureTimeout: "Time out when capturing",
+}
+
+// fakeAuthorization is a payment held by FakeGateway
+type fakeAuthorization struct {
+	amount    int
+	captured  int
+	refunded  int
+	voided    bool
+	reference string
+	// token is the card token it was authorized with, which decides how the capture goes
+	token string
+}
+
+// FakeGateway is an in-process PaymentGateway for development and tests. Its behaviour is chosen
+// by the card token: FakeTokenApprove approves, FakeTokenDecline is declined, and FakeTokenTimeout
+// times out. FakeTokenCaptureFail and FakeTokenCaptureTimeout are authorized, then fail or time out
+// when captured. Any other token is declined.
+type FakeGateway struct {
+	mu             sync.Mutex
+	nextID         int
+	authorizations map[string]*fakeAuthorization
+}
+
+// NewFakeGateway returns a FakeGateway holding no payments
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{authorizations: make(map[string]*fakeAuthorization)}
+}
+
+// Authorize holds amount if token is FakeTokenApprove, or one of the tokens that fail on capture
+func (g *FakeGateway) Authorize(ctx context.Context, token string, amount int, reference string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", ErrTimeout
+	}
+	if amount <= 0 {
+		return "", ErrInvalidAmount
+	}
+
+	switch token {
+	case FakeTokenApprove, FakeTokenCaptureFail, FakeTokenCaptureTimeout:
+	case FakeTokenTimeout:
+		return "", ErrTimeout
+	default:
+		return "", ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.nextID++
+	id := fmt.Sprintf("fake_auth_%d", g.nextID)
+	g.authorizations[id] = &fakeAuthorization{amount: amount, reference: reference, token: token}
+	return id, nil
+}
+
+// Capture takes up to the authorized amount; an authorization can only be captured once
+func (g *FakeGateway) Capture(ctx context.Context, authorizationID string, amount int) error {
+	if err := ctx.Err(); err != nil {
+		return ErrTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.authorizations[authorizationID]
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+	if amount <= 0 || amount > a.amount || a.captured > 0 || a.voided {
+		return ErrInvalidAmount
+	}
+
+	switch a.token {
+	case FakeTokenCaptureFail:
+		return ErrDeclined
+	case FakeTokenCaptureTimeout:
+		return ErrTimeout
+	}
+	a.captured = amount
+	return nil
+}
+
+// Refund gives back up to what is left of the captured amount
+func (g *FakeGateway) Refund(ctx context.Context, authorizationID string, amount int) error {
+	if err := ctx.Err(); err != nil {
+		return ErrTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.authorizations[authorizationID]
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+	if amount <= 0 || amount > a.captured-a.refunded {
+		return ErrInvalidAmount
+	}
+	a.refunded += amount
+	return nil
+}
+
+// Void lets go of an authorization that hasn't been captured
+func (g *FakeGateway) Void(ctx context.Context, authorizationID string) error {
+	if err := ctx.Err(); err != nil {
+		return ErrTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.authorizations[authorizationID]
+	if !ok {
+		return ErrUnknownAuthorization
+	}
+	if a.captured > 0 {
+		return ErrInvalidAmount
+	}
+	a.voided = true
+	return nil
+}
+
+// Lookup returns the latest authorization made with reference
+func (g *FakeGateway) Lookup(ctx context.Context, reference string) (Authorization, error) {
+	if err := ctx.Err(); err != nil {
+		return Authorization{}, ErrTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for n := g.nextID; n > 0; n-- {
+		id := fmt.Sprintf("fake_auth_%d", n)
+		if a := g.authorizations[id]; a.reference == reference {
+			return Authorization{ID: id, Amount: a.amount, Captured: a.captured, Voided: a.voided}, nil
+		}
+	}
+	return Authorization{}, ErrUnknownAuthorization
+}
+
+// Voided reports whether an authorization has been voided, for tests
+func (g *FakeGateway) Voided(authorizationID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.authorizations[authorizationID]
+	return ok && a.voided
+}
+
+// Captured returns the amount captured and not refunded on an authorization, for tests
+func (g *FakeGateway) Captured(authorizationID string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.authorizations[authorizationID]
+	if !ok {
+		return 0
+	}
+	return a.captured - a.refunded
+}
@@ -0,0 +1,139 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFakeGateway(t *testing.T) {
+	g := NewFakeGateway()
+	ctx := context.Background()
+
+	id, err := g.Authorize(ctx, FakeTokenApprove, 10000, "reservation 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Capture(ctx, id, 12000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected capturing more than was authorized to fail, got %v", err)
+	}
+	if err := g.Capture(ctx, id, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Capture(ctx, id, 10000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a second capture to fail, got %v", err)
+	}
+
+	if err := g.Refund(ctx, id, 4000); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Refund(ctx, id, 7000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected refunding more than is left to fail, got %v", err)
+	}
+	if got := g.Captured(id); got != 6000 {
+		t.Errorf("expected 6000 left after the refund, got %d", got)
+	}
+
+	if err := g.Capture(ctx, "nope", 100); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("expected ErrUnknownAuthorization, got %v", err)
+	}
+}
+
+func TestFakeGateway_Authorize(t *testing.T) {
+	g := NewFakeGateway()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		name        string
+		ctx         context.Context
+		token       string
+		amount      int
+		expectedErr error
+	}{
+		{"approved", context.Background(), FakeTokenApprove, 100, nil},
+		{"declined", context.Background(), FakeTokenDecline, 100, ErrDeclined},
+		{"unknown token", context.Background(), "tok_stolen", 100, ErrDeclined},
+		{"timeout", context.Background(), FakeTokenTimeout, 100, ErrTimeout},
+		{"cancelled request", cancelled, FakeTokenApprove, 100, ErrTimeout},
+		{"no amount", context.Background(), FakeTokenApprove, 0, ErrInvalidAmount},
+	}
+
+	for _, e := range tests {
+		id, err := g.Authorize(e.ctx, e.token, e.amount, "test")
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expectedErr, err)
+		}
+		if err == nil && id == "" {
+			t.Errorf("%s: expected an authorization id", e.name)
+		}
+	}
+}
+
+func TestFakeGateway_Void(t *testing.T) {
+	g := NewFakeGateway()
+	ctx := context.Background()
+
+	id, _ := g.Authorize(ctx, FakeTokenCaptureFail, 10000, "reservation 1")
+	if err := g.Capture(ctx, id, 10000); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected the capture to be declined, got %v", err)
+	}
+	if err := g.Void(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Voided(id) {
+		t.Error("expected the authorization to be voided")
+	}
+
+	id, _ = g.Authorize(ctx, FakeTokenCaptureTimeout, 10000, "reservation 2")
+	if err := g.Capture(ctx, id, 10000); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected the capture to time out, got %v", err)
+	}
+	if got := g.Captured(id); got != 0 {
+		t.Errorf("expected nothing captured, got %d", got)
+	}
+
+	id, _ = g.Authorize(ctx, FakeTokenApprove, 10000, "reservation 3")
+	if err := g.Void(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Capture(ctx, id, 10000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a voided authorization not to be captured, got %v", err)
+	}
+
+	id, _ = g.Authorize(ctx, FakeTokenApprove, 10000, "reservation 4")
+	_ = g.Capture(ctx, id, 10000)
+	if err := g.Void(ctx, id); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected a captured payment not to be voided, got %v", err)
+	}
+
+	if err := g.Void(ctx, "nope"); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("expected ErrUnknownAuthorization, got %v", err)
+	}
+}
+
+func TestFakeGateway_Lookup(t *testing.T) {
+	g := NewFakeGateway()
+	ctx := context.Background()
+
+	if _, err := g.Lookup(ctx, "reservation 1"); !errors.Is(err, ErrUnknownAuthorization) {
+		t.Errorf("expected ErrUnknownAuthorization before anything is authorized, got %v", err)
+	}
+
+	first, _ := g.Authorize(ctx, FakeTokenApprove, 10000, "reservation 1")
+	_ = g.Void(ctx, first)
+	second, _ := g.Authorize(ctx, FakeTokenApprove, 10000, "reservation 1")
+	_ = g.Capture(ctx, second, 8000)
+	_, _ = g.Authorize(ctx, FakeTokenApprove, 5000, "reservation 2")
+
+	a, err := g.Lookup(ctx, "reservation 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Authorization{ID: second, Amount: 10000, Captured: 8000}
+	if a != expected {
+		t.Errorf("expected the latest authorization %+v, got %+v", expected, a)
+	}
+}
@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Gateways that can be chosen in the settings
+const (
+	// GatewayFake is FakeGateway, which charges no cards; its test tokens are offered on the booking form
+	GatewayFake = "fake"
+)
+
+var (
+	// ErrDeclined is returned when the card issuer refuses a payment
+	ErrDeclined = errors.New("payment declined")
+	// ErrTimeout is returned when the gateway doesn't answer in time; the payment may or may not have gone through
+	ErrTimeout = errors.New("payment gateway timed out")
+	// ErrUnknownAuthorization is returned for an authorization id the gateway doesn't know
+	ErrUnknownAuthorization = errors.New("unknown authorization")
+	// ErrInvalidAmount is returned when an amount is not positive, or is more than what can be captured or refunded
+	ErrInvalidAmount = errors.New("invalid amount")
+)
+
+// Authorization is a payment as the gateway has it. Amounts are in cents.
+type Authorization struct {
+	ID string
+	// Amount is what was authorized, and Captured how much of it has been taken
+	Amount   int
+	Captured int
+	Voided   bool
+}
+
+// PaymentGateway takes card payments. Amounts are in cents. A payment is first authorized, which
+// holds the money on the card, then captured, which takes it; captured money can be refunded.
+type PaymentGateway interface {
+	// Authorize holds amount on the card identified by token, a one time token made by the
+	// gateway's card form, and returns the id of the authorization. Reference is shown to
+	// the guest on their statement.
+	Authorize(ctx context.Context, token string, amount int, reference string) (string, error)
+	// Capture takes up to the authorized amount
+	Capture(ctx context.Context, authorizationID string, amount int) error
+	// Refund gives back some or all of a captured amount
+	Refund(ctx context.Context, authorizationID string, amount int) error
+	// Void lets go of an authorization that hasn't been captured, so the money is no longer held
+	Void(ctx context.Context, authorizationID string) error
+	// Lookup returns the latest authorization made with reference, for when the answer to Authorize
+	// or Capture was lost. It returns ErrUnknownAuthorization if nothing was authorized with it.
+	Lookup(ctx context.Context, reference string) (Authorization, error)
+}
+
+// New returns the gateway called name
+func New(name string) (PaymentGateway, error) {
+	switch name {
+	case GatewayFake:
+		return NewFakeGateway(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
+}
@@ -0,0 +1,196 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/repository"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidStay is returned when a stay doesn't end after it starts
+var ErrInvalidStay = errors.New("a stay must be at least one night")
+
+// ErrNoRate is returned when a night of a stay has no price, because neither the room's base
+// rate nor one of its rates sets one; such a room can't be booked
+var ErrNoRate = errors.New("the room has no rate for the stay")
+
+// Night is the price of one night of a stay
+type Night struct {
+	Date time.Time
+	Rate int
+	// RateName is the name of the room rate that applied, or empty for the room's base rate
+	RateName string
+}
+
+// Quote is the price of a stay, night by night; amounts are in cents
+type Quote struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Nights    []Night
+	Total     int
+}
+
+// Service prices stays with the room rates stored in the database
+type Service struct {
+	DB repository.DatabaseRepo
+}
+
+// New returns a Service using db
+func New(db repository.DatabaseRepo) *Service {
+	return &Service{DB: db}
+}
+
+// Quote prices a stay in room from start up to the departure day end
+func (s *Service) Quote(ctx context.Context, room models.Room, start, end time.Time) (Quote, error) {
+	rates, err := s.DB.GetRoomRates(ctx, room.ID)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Calculate(room, rates, start, end)
+}
+
+// Calculate prices each night of a stay. A night costs the room's base rate, unless one of
+// rates covers it; when several do, the one covering the fewest days wins, so a holiday beats
+// a season. Between rates covering as many days, one limited to some weekdays wins, and then
+// the newest one. A night that would cost nothing is ErrNoRate, so a room whose rate was never
+// set isn't given away.
+func Calculate(room models.Room, rates []models.RoomRate, start, end time.Time) (Quote, error) {
+	start, end = date(start), date(end)
+	if !end.After(start) {
+		return Quote{}, ErrInvalidStay
+	}
+
+	q := Quote{StartDate: start, EndDate: end}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		n := Night{Date: d, Rate: room.NightlyRate}
+		if rate, ok := rateFor(rates, d); ok {
+			n.Rate = rate.NightlyRate
+			n.RateName = rate.Name
+		}
+		if n.Rate <= 0 {
+			return Quote{}, fmt.Errorf("%w: no price for the night of %s", ErrNoRate, d.Format("2006-01-02"))
+		}
+		q.Nights = append(q.Nights, n)
+		q.Total += n.Rate
+	}
+	return q, nil
+}
+
+// rateFor returns the most specific of rates that covers the night of d
+func rateFor(rates []models.RoomRate, d time.Time) (models.RoomRate, bool) {
+	var best models.RoomRate
+	found := false
+	for _, r := range rates {
+		if !covers(r, d) {
+			continue
+		}
+		if !found || moreSpecific(r, best) {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+// covers says whether r applies to the night of d
+func covers(r models.RoomRate, d time.Time) bool {
+	if d.Before(date(r.StartDate)) || d.After(date(r.EndDate)) {
+		return false
+	}
+	return r.Weekdays == 0 || r.Weekdays&WeekdayMask(d.Weekday()) != 0
+}
+
+// moreSpecific says whether a takes precedence over b
+func moreSpecific(a, b models.RoomRate) bool {
+	if da, db := days(a), days(b); da != db {
+		return da < db
+	}
+	if (a.Weekdays != 0) != (b.Weekdays != 0) {
+		return a.Weekdays != 0
+	}
+	return a.ID > b.ID
+}
+
+// days returns the number of days from the start to the end of r, inclusive
+func days(r models.RoomRate) int {
+	return int(date(r.EndDate).Sub(date(r.StartDate)).Hours()/24) + 1
+}
+
+// date returns the calendar date of t as midnight UTC
+func date(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// WeekdayMask returns the bit of a weekday in a RoomRate's Weekdays
+func WeekdayMask(days ...time.Weekday) int {
+	mask := 0
+	for _, d := range days {
+		mask |= 1 << uint(d)
+	}
+	return mask
+}
+
+// WeekdayNames lists the days in a RoomRate's Weekdays, like "Fri, Sat"
+func WeekdayNames(mask int) string {
+	if mask == 0 {
+		return "Every day"
+	}
+
+	var names []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if mask&WeekdayMask(d) != 0 {
+			names = append(names, d.String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// FormatMoney formats an amount in cents, like $1,234.50
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	dollars := strconv.Itoa(cents / 100)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+// ParseMoney reads an amount like 1234.5 or $1,234.50 as cents
+func ParseMoney(s string) (int, error) {
+	s = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(s), "$"), ",", "")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	dollars, err := strconv.ParseUint(whole, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	// amounts are stored in integer columns
+	total := dollars*100 + cents
+	if total > math.MaxInt32 {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	return int(total), nil
+}
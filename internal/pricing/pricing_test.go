@@ -0,0 +1,149 @@
+package pricing_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/pricing"
+	"github.com/jjang65/booking-web-app/internal/repository/dbrepo"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var testRates = []models.RoomRate{
+	{ID: 1, Name: "Summer", StartDate: day("2050-06-01"), EndDate: day("2050-08-31"), NightlyRate: 15000},
+	{ID: 2, Name: "Weekend", StartDate: day("2050-06-01"), EndDate: day("2050-08-31"), Weekdays: pricing.WeekdayMask(time.Friday, time.Saturday), NightlyRate: 18000},
+	{ID: 3, Name: "Canada Day", StartDate: day("2050-07-01"), EndDate: day("2050-07-01"), NightlyRate: 25000},
+}
+
+func TestCalculate(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+
+	// 2050-05-31 is a Tuesday
+	q, err := pricing.Calculate(room, testRates, day("2050-05-31"), day("2050-06-03"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Nights) != 3 || q.Total != 40000 {
+		t.Errorf("wrong quote: %+v", q)
+	}
+	if q.Nights[0].RateName != "" || q.Nights[1].RateName != "Summer" {
+		t.Errorf("wrong rates: %+v", q.Nights)
+	}
+
+	var tests = []struct {
+		name     string
+		night    string
+		rate     int
+		rateName string
+	}{
+		{"base rate", "2050-01-05", 10000, ""},
+		{"season", "2050-06-06", 15000, "Summer"},
+		{"weekend in season", "2050-06-10", 18000, "Weekend"},
+		{"holiday on a weekend", "2050-07-01", 25000, "Canada Day"},
+		{"day after the season", "2050-09-01", 10000, ""},
+	}
+
+	for _, e := range tests {
+		q, err := pricing.Calculate(room, testRates, day(e.night), day(e.night).AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := q.Nights[0]
+		if n.Rate != e.rate || n.RateName != e.rateName || q.Total != e.rate {
+			t.Errorf("%s: got %d (%q), wanted %d (%q)", e.name, n.Rate, n.RateName, e.rate, e.rateName)
+		}
+	}
+
+	if _, err := pricing.Calculate(room, testRates, day("2050-06-03"), day("2050-06-03")); !errors.Is(err, pricing.ErrInvalidStay) {
+		t.Errorf("expected ErrInvalidStay for a stay without nights, got %v", err)
+	}
+
+	// a room without a base rate can only be priced on the nights its rates cover
+	unpriced := models.Room{ID: 1}
+	if _, err := pricing.Calculate(unpriced, testRates, day("2050-06-06"), day("2050-06-08")); err != nil {
+		t.Errorf("expected the season to price the stay, got %v", err)
+	}
+	if _, err := pricing.Calculate(unpriced, testRates, day("2050-05-31"), day("2050-06-02")); !errors.Is(err, pricing.ErrNoRate) {
+		t.Errorf("expected ErrNoRate for a night without a rate, got %v", err)
+	}
+}
+
+func TestService_Quote(t *testing.T) {
+	s := pricing.New(dbrepo.NewTestingRepo(&config.AppConfig{}))
+
+	q, err := s.Quote(context.Background(), models.Room{ID: 1, NightlyRate: 10000}, day("2050-12-30"), day("2051-01-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a Friday at the weekend rate, then New Year's Eve
+	if q.Total != 32500 {
+		t.Errorf("wrong total: %d", q.Total)
+	}
+
+	if _, err := s.Quote(context.Background(), models.Room{ID: 3}, day("2050-12-30"), day("2051-01-01")); err == nil {
+		t.Error("expected an error when the rates can't be loaded")
+	}
+}
+
+func TestWeekdayNames(t *testing.T) {
+	if got := pricing.WeekdayNames(0); got != "Every day" {
+		t.Errorf("got %q", got)
+	}
+	if got := pricing.WeekdayNames(pricing.WeekdayMask(time.Saturday, time.Friday)); got != "Fri, Sat" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	var tests = []struct {
+		cents int
+		out   string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{12550, "$125.50"},
+		{123456789, "$1,234,567.89"},
+		{-1000, "-$10.00"},
+	}
+
+	for _, e := range tests {
+		if got := pricing.FormatMoney(e.cents); got != e.out {
+			t.Errorf("FormatMoney(%d) = %q, wanted %q", e.cents, got, e.out)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	var tests = []struct {
+		in        string
+		cents     int
+		expectErr bool
+	}{
+		{"120", 12000, false},
+		{"120.5", 12050, false},
+		{" $1,234.56 ", 123456, false},
+		{"0.05", 5, false},
+		{"", 0, true},
+		{"-5", 0, true},
+		{"1.234", 0, true},
+		{"cheap", 0, true},
+		{"99999999999", 0, true},
+	}
+
+	for _, e := range tests {
+		cents, err := pricing.ParseMoney(e.in)
+		if e.expectErr && err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", e.in)
+		}
+		if !e.expectErr && (err != nil || cents != e.cents) {
+			t.Errorf("ParseMoney(%q) = %d, %v, wanted %d", e.in, cents, err, e.cents)
+		}
+	}
+}
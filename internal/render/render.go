@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/helpers"
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/pricing"
 	"github.com/justinas/nosurf"
 	"html/template"
 	"net/http"
@@ -15,7 +18,17 @@ import (
 
 // Init functions which type is FuncMap defining the mapping from names to functions.
 var functions = template.FuncMap{
-	"humanDate": HumanDate,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"formatMoney": pricing.FormatMoney,
+	"weekdays":    pricing.WeekdayNames,
+	"statusLabel": lifecycle.Label,
+	// staff access levels, so templates don't hardcode their numbers
+	"accessLevels":    AccessLevels,
+	"accessLevelName": AccessLevelName,
+	"canEdit":         CanEdit,
+	"canManage":       CanManage,
 }
 
 // app is the pointer to AppConfig
@@ -32,6 +45,58 @@ func HumanDate(t time.Time) string {
 	return t.Format("2006-01-02")
 }
 
+// FormatDate returns time in the given layout for the template format
+func FormatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// Iterate returns a slice of ints, starting at 1, going to count
+func Iterate(count int) []int {
+	var items []int
+	for i := 1; i <= count; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+// StaffAccessLevels names the access levels of staff users
+type StaffAccessLevels struct {
+	Viewer    int
+	FrontDesk int
+	Owner     int
+}
+
+// AccessLevels returns the access levels of staff users, for picking one in a form
+func AccessLevels() StaffAccessLevels {
+	return StaffAccessLevels{
+		Viewer:    models.AccessLevelViewer,
+		FrontDesk: models.AccessLevelFrontDesk,
+		Owner:     models.AccessLevelOwner,
+	}
+}
+
+// AccessLevelName returns the name of an access level as people see it
+func AccessLevelName(level int) string {
+	switch level {
+	case models.AccessLevelOwner:
+		return "Owner"
+	case models.AccessLevelFrontDesk:
+		return "Front Desk"
+	default:
+		return "Viewer"
+	}
+}
+
+// CanEdit says whether u can change reservations and blocks
+func CanEdit(u models.User) bool {
+	return u.AccessLevel >= models.AccessLevelFrontDesk
+}
+
+// CanManage says whether u can also manage rooms and users
+func CanManage(u models.User) bool {
+	return u.AccessLevel >= models.AccessLevelOwner
+}
+
 // CreateTemplateCache creates a template cache as a map
 func CreateTemplateCache() (map[string]*template.Template, error) {
 	// Init map containing string key and pointer to Template
@@ -83,6 +148,9 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if u, ok := helpers.AuthUser(r); ok {
+		td.AuthUser = u
+	}
 	return td
 }
 
@@ -74,3 +74,26 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestAccessLevelFuncs(t *testing.T) {
+	var tests = []struct {
+		level     int
+		name      string
+		canEdit   bool
+		canManage bool
+	}{
+		{models.AccessLevelViewer, "Viewer", false, false},
+		{models.AccessLevelFrontDesk, "Front Desk", true, false},
+		{models.AccessLevelOwner, "Owner", true, true},
+	}
+
+	for _, e := range tests {
+		u := models.User{AccessLevel: e.level}
+		if got := AccessLevelName(e.level); got != e.name {
+			t.Errorf("level %d: expected the name %q, got %q", e.level, e.name, got)
+		}
+		if CanEdit(u) != e.canEdit || CanManage(u) != e.canManage {
+			t.Errorf("%s: expected canEdit %t and canManage %t", e.name, e.canEdit, e.canManage)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"github.com/jjang65/booking-web-app/internal/config"
+	"github.com/jjang65/booking-web-app/internal/models"
 	"github.com/jjang65/booking-web-app/internal/repository"
+	"sync"
+	"time"
 )
 
 type postgresDbRepo struct {
@@ -14,6 +18,10 @@ type postgresDbRepo struct {
 type testDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// the test outbox hands mail straight to App.Mailer, apart from the held mail about new reservations
+	mu   sync.Mutex
+	held map[int][]models.MailData
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -23,9 +31,22 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
+// defaultTimeout bounds queries when the config doesn't set a timeout
+const defaultTimeout = 3 * time.Second
+
+// withTimeout returns a context for a query, which ends with ctx or after the configured timeout
+func (m *postgresDbRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.DBTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDbRepo{
-		App: a,
-		DB:  nil,
+		App:  a,
+		DB:   nil,
+		held: make(map[int][]models.MailData),
 	}
 }
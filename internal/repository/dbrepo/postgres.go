@@ -2,29 +2,100 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"strings"
 	"time"
 )
 
-func (m *postgresDbRepo) AllUsers() bool {
-	return true
+// holdsRoom matches the room restrictions, aliased rr, that keep a room off the market: owner blocks,
+// imported bookings, and reservations that have not been cancelled or ended in a no-show
+var holdsRoom = fmt.Sprintf(`(rr.reservation_id IS NULL OR rr.reservation_id NOT IN (
+	SELECT id FROM reservations WHERE status IN ('%s')))`, strings.Join(lifecycle.Released(), "', '"))
+
+// AllUsers returns all users
+func (m *postgresDbRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var users []models.User
+
+	query := `
+		SELECT id, first_name, last_name, email, access_level, active, created_at, updated_at
+			FROM users
+			ORDER BY last_name, first_name
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.Active,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
 }
 
-// InsertReservation inserts a reservation into db that returns reservation_id and error
-func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertReservation inserts a reservation, and the room restriction that holds its room, returning
+// the reservation's id. It returns ErrRoomUnavailable if the room is taken for any of its nights:
+// the exclusion constraint on room_restrictions sees to it that two reservations never overlap,
+// even when both were checked for availability at the same moment.
+// The mail about the reservation is written to the outbox in the same transaction, and held there.
+func (m *postgresDbRepo) InsertReservation(ctx context.Context, res models.Reservation, mail ...models.MailData) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var newID int
 
+	status := res.Status
+	if status == "" {
+		status = models.ReservationPending
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	// a taken code inserts nothing, so no id comes back
 	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, start_date, 
-			end_date, room_id, created_at, updated_at) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+			end_date, room_id, total, status, code, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (code) DO NOTHING returning id`
 	// QueryRowContext executes a statement and returns the most recent row
-	err := m.DB.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		stmt,
 		res.FirstName,
@@ -34,50 +105,100 @@ func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Total,
+		status,
+		res.Code,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrDuplicateCode
+	} else if err != nil {
 		return 0, err
 	}
 
-	return newID, nil
-}
+	blocked, err := roomBlocked(ctx, tx, res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, repository.ErrRoomUnavailable
+	}
 
-// InsertRoomRestriction inserts a room restriction into db
-func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id,
+	stmt = `INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id,
 			created_at, updated_at, restriction_id) 
 			VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := m.DB.ExecContext(
+	_, err = tx.ExecContext(
 		ctx,
 		stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		r.ReservationID,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
 		time.Now(),
 		time.Now(),
-		r.RestrictionID,
+		models.RestrictionReservation,
 	)
-	log.Println("r.RestrictionID: ", r.RestrictionID)
+	if isExclusionViolation(err) {
+		return 0, repository.ErrRoomUnavailable
+	} else if err != nil {
+		return 0, err
+	}
+
+	// the mail goes out once the booking has gone through, see ReleaseReservationMail
+	for _, msg := range mail {
+		err = insertMail(ctx, tx, msg, newID, models.MailHeld)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		log.Println("InsertRoomRestriction::err:", err)
-		return err
+		return 0, err
 	}
-	return nil
+
+	return newID, nil
+}
+
+// lockRoom locks the row of a room until tx ends, so changes to its calendar are made one at a time.
+// Without it, a booking and a block made at once could each find the room free, and both take it.
+func lockRoom(ctx context.Context, tx *sql.Tx, roomID int) error {
+	var id int
+	return tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&id)
+}
+
+// roomBlocked says whether an owner block or a booking imported from another platform takes a room
+// on any night from start to end. Those aren't covered by the exclusion constraint, so they are looked
+// for here; the room must be locked, so one can't be added after the check.
+func roomBlocked(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time) (bool, error) {
+	var blocked bool
+	query := `SELECT EXISTS (
+			SELECT 1 FROM room_restrictions
+				WHERE room_id = $1 AND reservation_id IS NULL AND $2 < end_date AND $3 > start_date
+		)`
+	err := tx.QueryRowContext(ctx, query, roomID, start, end).Scan(&blocked)
+	return blocked, err
+}
+
+// exclusionViolation is the Postgres error code for a row that conflicts with an exclusion constraint
+const exclusionViolation = "23P01"
+
+// isExclusionViolation says whether err is Postgres refusing a row because of an exclusion constraint
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == exclusionViolation
 }
 
 // SearchAvailabilityByDatesByRoomID returns ture if availability exists for roomID, and false if no availability
-func (m *postgresDbRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	query := `SELECT COUNT(id)
-				FROM room_restrictions
+				FROM room_restrictions rr
 				WHERE room_id = $1
-				    AND $2 <= end_date and $3 >= start_date;`
+				    AND $2 < end_date AND $3 > start_date
+				    AND ` + holdsRoom + `;`
 	var numRows int
 	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
 	err := row.Scan(&numRows)
@@ -92,16 +213,18 @@ func (m *postgresDbRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	query := `
-		SELECT r.id, r.room_name
+		SELECT r.id, r.room_name, r.slug
 			FROM rooms r
-			WHERE r.id not in (
+			WHERE r.active = true
+				AND r.id not in (
 				SELECT room_id FROM room_restrictions rr 
 					WHERE $1 < rr.end_date
 						AND $2 > rr.start_date
+						AND ` + holdsRoom + `
 			);
 	`
 	var rooms []models.Room
@@ -115,6 +238,7 @@ func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.Slug,
 		)
 		if err != nil {
 			return rooms, err
@@ -128,14 +252,15 @@ func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 }
 
 // GetRoomByID gets a room by id
-func (m *postgresDbRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var room models.Room
 
 	query := `
-		SELECT id, room_name, created_at, updated_at
+		SELECT id, room_name, slug, description, image, active, ical_token, ical_import_url, nightly_rate,
+			created_at, updated_at
 			FROM rooms
 			WHERE id = $1
 	`
@@ -143,6 +268,13 @@ func (m *postgresDbRepo) GetRoomByID(id int) (models.Room, error) {
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Image,
+		&room.Active,
+		&room.ICalToken,
+		&room.ICalImportURL,
+		&room.NightlyRate,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -154,12 +286,12 @@ func (m *postgresDbRepo) GetRoomByID(id int) (models.Room, error) {
 }
 
 // GetUserByID returns a user by ID
-func (m *postgresDbRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `
-		SELECT id, first_name, last_name, email, password, access_level, created_at, updated_at
+		SELECT id, first_name, last_name, email, password, access_level, active, created_at, updated_at
 			FROM users
 			WHERE id = $1
 	`
@@ -170,7 +302,38 @@ func (m *postgresDbRepo) GetUserByID(id int) (models.User, error) {
 		&u.FirstName,
 		&u.LastName,
 		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// GetUserByEmail returns a user by email address
+func (m *postgresDbRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, first_name, last_name, email, password, access_level, active, created_at, updated_at
+			FROM users
+			WHERE lower(email) = lower($1)
+	`
+	row := m.DB.QueryRowContext(ctx, query, email)
+	var u models.User
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
 		&u.AccessLevel,
+		&u.Active,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -180,13 +343,42 @@ func (m *postgresDbRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
+// InsertUser inserts a user into the db; u.Password must already be hashed
+func (m *postgresDbRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		u.AccessLevel,
+		u.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
 // UpdateUser updates a user in the db
-func (m *postgresDbRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `
-		UPDATE users SET first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5 
+		UPDATE users SET first_name = $1, last_name = $2, email = $3, access_level = $4, active = $5, updated_at = $6
+			WHERE id = $7
 	`
 	_, err := m.DB.ExecContext(
 		ctx,
@@ -195,7 +387,9 @@ func (m *postgresDbRepo) UpdateUser(u models.User) error {
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
-		u.UpdatedAt,
+		u.Active,
+		time.Now(),
+		u.ID,
 	)
 	if err != nil {
 		return err
@@ -203,28 +397,48 @@ func (m *postgresDbRepo) UpdateUser(u models.User) error {
 	return nil
 }
 
-// Authenticate authenticates a user
-func (m *postgresDbRepo) Authenticate(email, password string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateUserPassword stores a new, already hashed, password for a user
+func (m *postgresDbRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Authenticate authenticates a user; the email address is matched ignoring case
+func (m *postgresDbRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var id int
 	var hashedPassword string
+	var active bool
 
 	query := `
-		SELECT id, password
+		SELECT id, password, active
 			FROM users
-			WHERE email = $1
+			WHERE lower(email) = lower($1)
 	`
 	row := m.DB.QueryRowContext(ctx, query, email)
 	err := row.Scan(
 		&id,
 		&hashedPassword,
+		&active,
 	)
 	if err != nil {
 		return id, "", err
 	}
 
+	if !active {
+		return 0, "", errors.New("account is deactivated")
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err == bcrypt.ErrMismatchedHashAndPassword {
 		return 0, "", errors.New("incorrect password")
@@ -236,15 +450,15 @@ func (m *postgresDbRepo) Authenticate(email, password string) (int, string, erro
 }
 
 // AllReservations returns a slice of all reservations
-func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDbRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var reservations []models.Reservation
 
 	query := `
 		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, 
-			r.created_at, r.updated_at, rm.id, rm.room_name
+			r.created_at, r.updated_at, rm.id, rm.room_name, r.status, r.code
 			FROM reservations r
 			LEFT JOIN rooms rm ON (r.room_id = rm.id)
 			ORDER BY r.start_date ASC
@@ -269,6 +483,8 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 			&i.UpdatedAt,
 			&i.Room.ID,
 			&i.Room.RoomName,
+			&i.Status,
+			&i.Code,
 		)
 		if err != nil {
 			return reservations, err
@@ -282,22 +498,22 @@ func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-// AllNewReservations returns a slice of all reservations
-func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllNewReservations returns a slice of the reservations still waiting to be confirmed
+func (m *postgresDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	var reservations []models.Reservation
 
 	query := `
 		SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, 
-			r.created_at, r.updated_at, rm.id, rm.room_name, r.processed
+			r.created_at, r.updated_at, rm.id, rm.room_name, r.status, r.code
 			FROM reservations r
 			LEFT JOIN rooms rm ON (r.room_id = rm.id)
-			WHERE processed = 0
+			WHERE r.status = $1
 			ORDER BY r.start_date ASC
 	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationPending)
 	if err != nil {
 		return reservations, err
 	}
@@ -317,7 +533,8 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.UpdatedAt,
 			&i.Room.ID,
 			&i.Room.RoomName,
-			&i.Processed,
+			&i.Status,
+			&i.Code,
 		)
 		if err != nil {
 			return reservations, err
@@ -330,3 +547,1321 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 	}
 	return reservations, nil
 }
+
+// GetReservationByID returns one reservation by ID
+func (m *postgresDbRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.id = $1", id)
+}
+
+// GetReservationByCode returns one reservation by its confirmation code
+func (m *postgresDbRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.code = $1", code)
+}
+
+// getReservation returns the reservation matching where, which has one parameter, arg
+func (m *postgresDbRepo) getReservation(ctx context.Context, where string, arg interface{}) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+		SELECT r.id, r.code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, 
+			r.total, r.created_at, r.updated_at, r.status, rm.id, rm.room_name
+			FROM reservations r
+			LEFT JOIN rooms rm ON (r.room_id = rm.id)
+			WHERE ` + where
+	row := m.DB.QueryRowContext(ctx, query, arg)
+	err := row.Scan(
+		&res.ID,
+		&res.Code,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.Total,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Status,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// UpdateReservation updates a reservation in the db, its total included, and moves its room restriction
+// to the new dates; it returns ErrRoomUnavailable if another reservation, a block or an imported booking
+// has the room on any of them
+func (m *postgresDbRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT room_id FROM reservations WHERE id = $1`, u.ID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := roomBlocked(ctx, tx, roomID, u.StartDate, u.EndDate)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return repository.ErrRoomUnavailable
+	}
+
+	query := `
+		UPDATE reservations SET first_name = $1, last_name = $2, email = $3, phone = $4,
+			start_date = $5, end_date = $6, total = $7, updated_at = $8
+			WHERE id = $9
+	`
+	_, err = tx.ExecContext(
+		ctx,
+		query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Phone,
+		u.StartDate,
+		u.EndDate,
+		u.Total,
+		time.Now(),
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Keep the room restriction in sync, so availability reflects the new dates
+	query = `
+		UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3
+			WHERE reservation_id = $4
+	`
+	_, err = tx.ExecContext(ctx, query, u.StartDate, u.EndDate, time.Now(), u.ID)
+	if isExclusionViolation(err) {
+		return repository.ErrRoomUnavailable
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReservation deletes one reservation by ID, along with its room restriction and its held mail.
+// It returns sql.ErrNoRows if there is no reservation with that id.
+func (m *postgresDbRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	// mail that went out stays in the outbox, but held mail about a booking that never happened goes
+	_, err = tx.ExecContext(ctx, `DELETE FROM mail_messages WHERE reservation_id = $1 AND status = $2`, id, models.MailHeld)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM reservations WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// UpdateReservationStatus moves a reservation to a new status, if the lifecycle allows it, and records
+// who made the change; userID is 0 for changes made by the guest. The mail about the change is
+// written to the outbox in the same transaction.
+func (m *postgresDbRepo) UpdateReservationStatus(ctx context.Context, id int, status string, userID int, mail ...models.MailData) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the row, so two changes at once can't both pass the check
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM reservations WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	err = lifecycle.Check(current, status)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET status = $1, updated_at = $2 WHERE id = $3`, status, now, id)
+	if err != nil {
+		return err
+	}
+
+	// a released room can be booked again, so the exclusion constraint leaves its restriction out
+	if !lifecycle.HoldsRoom(status) {
+		stmt := `UPDATE room_restrictions SET released = true, updated_at = $1 WHERE reservation_id = $2`
+		_, err = tx.ExecContext(ctx, stmt, now, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `INSERT INTO reservation_status_changes (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt, id, current, status, sql.NullInt64{Int64: int64(userID), Valid: userID > 0}, now, now)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range mail {
+		err = insertMail(ctx, tx, msg, id, models.MailPending)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetReservationStatusChanges returns the status history of a reservation, oldest first
+func (m *postgresDbRepo) GetReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	query := `
+		SELECT c.id, c.reservation_id, c.from_status, c.to_status, coalesce(c.user_id, 0), c.created_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, '')
+			FROM reservation_status_changes c
+			LEFT JOIN users u ON (c.user_id = u.id)
+			WHERE c.reservation_id = $1
+			ORDER BY c.created_at, c.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.CreatedAt,
+			&c.User.FirstName,
+			&c.User.LastName,
+		)
+		if err != nil {
+			return changes, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// AllRooms returns all rooms, including retired ones
+func (m *postgresDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	query := `
+		SELECT id, room_name, slug, description, image, active, ical_token, ical_import_url, nightly_rate,
+			created_at, updated_at
+			FROM rooms
+			ORDER BY room_name
+	`
+	return m.queryRooms(ctx, query)
+}
+
+// AllActiveRooms returns all rooms that have not been retired
+func (m *postgresDbRepo) AllActiveRooms(ctx context.Context) ([]models.Room, error) {
+	query := `
+		SELECT id, room_name, slug, description, image, active, ical_token, ical_import_url, nightly_rate,
+			created_at, updated_at
+			FROM rooms
+			WHERE active = true
+			ORDER BY room_name
+	`
+	return m.queryRooms(ctx, query)
+}
+
+// queryRooms runs a query selecting full room rows, and returns them as a slice
+func (m *postgresDbRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Slug,
+			&rm.Description,
+			&rm.Image,
+			&rm.Active,
+			&rm.ICalToken,
+			&rm.ICalImportURL,
+			&rm.NightlyRate,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *postgresDbRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		SELECT id, room_name, slug, description, image, active, ical_token, ical_import_url, nightly_rate,
+			created_at, updated_at
+			FROM rooms
+			WHERE slug = $1
+	`
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Image,
+		&room.Active,
+		&room.ICalToken,
+		&room.ICalImportURL,
+		&room.NightlyRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+	return room, nil
+}
+
+// InsertRoom inserts a room into the db, and returns its id
+func (m *postgresDbRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO rooms (room_name, slug, description, image, active, ical_token, ical_import_url,
+			nightly_rate, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		stmt,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Image,
+		r.Active,
+		r.ICalToken,
+		r.ICalImportURL,
+		r.NightlyRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoom updates a room in the db
+func (m *postgresDbRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE rooms SET room_name = $1, slug = $2, description = $3, image = $4, active = $5,
+			ical_import_url = $6, nightly_rate = $7, updated_at = $8
+			WHERE id = $9
+	`
+	_, err := m.DB.ExecContext(
+		ctx,
+		query,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Image,
+		r.Active,
+		r.ICalImportURL,
+		r.NightlyRate,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateRoomICalToken replaces the secret token of a room's calendar feed.
+// It returns sql.ErrNoRows if there is no room with that id.
+func (m *postgresDbRepo) UpdateRoomICalToken(ctx context.Context, id int, token string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE rooms SET ical_token = $1, updated_at = $2 WHERE id = $3`
+
+	result, err := m.DB.ExecContext(ctx, query, token, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room overlapping a date range
+func (m *postgresDbRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date, updated_at
+			FROM room_restrictions rr
+			WHERE $1 < end_date AND $2 >= start_date AND room_id = $3
+				AND ` + holdsRoom + `
+	`
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts an owner block for a single night. It returns ErrRoomUnavailable if a
+// reservation or a booking imported from another platform already takes the room that night.
+func (m *postgresDbRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// waits for a booking of the room that is going through
+	err = lockRoom(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	endDate := startDate.AddDate(0, 0, 1)
+
+	var taken bool
+	query := `SELECT EXISTS (
+			SELECT 1 FROM room_restrictions rr
+				WHERE room_id = $1 AND restriction_id <> $2 AND $3 < end_date AND $4 > start_date
+					AND ` + holdsRoom + `
+		)`
+	err = tx.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock, startDate, endDate).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return repository.ErrRoomUnavailable
+	}
+
+	query = `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err = tx.ExecContext(
+		ctx,
+		query,
+		startDate,
+		endDate,
+		id,
+		models.RestrictionOwnerBlock,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteBlockNights takes nights out of an owner block; the nights of the block either side of them
+// stay blocked
+func (m *postgresDbRepo) DeleteBlockNights(ctx context.Context, id int, nights []time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var start, end time.Time
+	query := `SELECT room_id, start_date, end_date FROM room_restrictions
+			WHERE id = $1 AND restriction_id = $2 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(&roomID, &start, &end)
+	if errors.Is(err, sql.ErrNoRows) {
+		// already gone
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	runs := blockRuns(start, end, nights)
+	if len(runs) == 1 && runs[0][0].Equal(start) && runs[0][1].Equal(end) {
+		// none of the nights are in the block
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+	for _, run := range runs {
+		_, err = tx.ExecContext(ctx, stmt, run[0], run[1], roomID, models.RestrictionOwnerBlock, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// blockRuns returns the runs of nights from start up to end that are left when nights are taken out,
+// as the start and end date of each
+func blockRuns(start, end time.Time, nights []time.Time) [][2]time.Time {
+	removed := make(map[string]bool)
+	for _, n := range nights {
+		removed[n.Format("2006-01-02")] = true
+	}
+
+	var runs [][2]time.Time
+	runStart := start
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if removed[d.Format("2006-01-02")] {
+			if d.After(runStart) {
+				runs = append(runs, [2]time.Time{runStart, d})
+			}
+			runStart = d.AddDate(0, 0, 1)
+		}
+	}
+	if end.After(runStart) {
+		runs = append(runs, [2]time.Time{runStart, end})
+	}
+	return runs
+}
+
+// GetExternalRestrictionsForRoom returns all the restrictions imported from other platforms for a room
+func (m *postgresDbRepo) GetExternalRestrictionsForRoom(ctx context.Context, roomID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT id, restriction_id, room_id, start_date, end_date, external_uid, updated_at
+			FROM room_restrictions
+			WHERE room_id = $1 AND restriction_id = $2
+			ORDER BY start_date
+	`
+	rows, err := m.DB.QueryContext(ctx, query, roomID, models.RestrictionExternal)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.ExternalUID,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// SyncExternalRestrictions inserts, updates and deletes a room's external restrictions in one transaction
+func (m *postgresDbRepo) SyncExternalRestrictions(ctx context.Context, roomID int, inserts, updates []models.RoomRestriction, deleteIDs []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// waits for a booking of the room that is going through
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range inserts {
+		stmt := `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id, external_uid,
+				created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, roomID, models.RestrictionExternal,
+			r.ExternalUID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range updates {
+		stmt := `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3
+				WHERE id = $4 AND room_id = $5 AND restriction_id = $6`
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, time.Now(), r.ID, roomID, models.RestrictionExternal)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range deleteIDs {
+		stmt := `DELETE FROM room_restrictions WHERE id = $1 AND room_id = $2 AND restriction_id = $3`
+		_, err = tx.ExecContext(ctx, stmt, id, roomID, models.RestrictionExternal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomRates returns all the rates of a room
+func (m *postgresDbRepo) GetRoomRates(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rates []models.RoomRate
+
+	query := `
+		SELECT id, room_id, name, start_date, end_date, weekdays, nightly_rate, created_at, updated_at
+			FROM room_rates
+			WHERE room_id = $1
+			ORDER BY start_date, end_date
+	`
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.Weekdays,
+			&r.NightlyRate,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+// InsertRoomRate inserts a room rate into the db, and returns its id
+func (m *postgresDbRepo) InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO room_rates (room_id, name, start_date, end_date, weekdays, nightly_rate,
+			created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		stmt,
+		r.RoomID,
+		r.Name,
+		r.StartDate,
+		r.EndDate,
+		r.Weekdays,
+		r.NightlyRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteRoomRate deletes one of a room's rates
+func (m *postgresDbRepo) DeleteRoomRate(ctx context.Context, roomID, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM room_rates WHERE id = $1 AND room_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, roomID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertPayment inserts a payment into the db, and returns its id
+func (m *postgresDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO payments (reservation_id, amount, refunded, refund_due, status, authorization_id, error,
+			created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(
+		ctx,
+		stmt,
+		p.ReservationID,
+		p.Amount,
+		p.Refunded,
+		p.RefundDue,
+		p.Status,
+		p.AuthorizationID,
+		p.Error,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdatePayment records what happened to a payment at the gateway
+func (m *postgresDbRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE payments SET refunded = $1, refund_due = $2, status = $3, authorization_id = $4, error = $5,
+			updated_at = $6
+			WHERE id = $7
+	`
+	_, err := m.DB.ExecContext(ctx, query, p.Refunded, p.RefundDue, p.Status, p.AuthorizationID, p.Error,
+		time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments made for a reservation, oldest first
+func (m *postgresDbRepo) GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `
+		SELECT id, reservation_id, amount, refunded, refund_due, status, authorization_id, error, created_at,
+			updated_at
+			FROM payments
+			WHERE reservation_id = $1
+			ORDER BY created_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Amount,
+			&p.Refunded,
+			&p.RefundDue,
+			&p.Status,
+			&p.AuthorizationID,
+			&p.Error,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+	return payments, nil
+}
+
+// GetUnsettledPayments returns the payments, made before a time, that were left pending or authorized
+// because the gateway didn't answer, oldest first
+func (m *postgresDbRepo) GetUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `
+		SELECT id, reservation_id, amount, refunded, refund_due, status, authorization_id, error, created_at,
+			updated_at
+			FROM payments
+			WHERE status IN ($1, $2) AND reservation_id IS NOT NULL AND created_at < $3
+			ORDER BY created_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query, models.PaymentPending, models.PaymentAuthorized, before)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Amount,
+			&p.Refunded,
+			&p.RefundDue,
+			&p.Status,
+			&p.AuthorizationID,
+			&p.Error,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+	return payments, nil
+}
+
+// GetPaymentsWithRefundDue returns the payments that still have money to be refunded, oldest first
+func (m *postgresDbRepo) GetPaymentsWithRefundDue(ctx context.Context) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `
+		SELECT id, reservation_id, amount, refunded, refund_due, status, authorization_id, error, created_at,
+			updated_at
+			FROM payments
+			WHERE refund_due > 0
+			ORDER BY created_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		var reservationID sql.NullInt64
+		err := rows.Scan(
+			&p.ID,
+			&reservationID,
+			&p.Amount,
+			&p.Refunded,
+			&p.RefundDue,
+			&p.Status,
+			&p.AuthorizationID,
+			&p.Error,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		p.ReservationID = int(reservationID.Int64)
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+	return payments, nil
+}
+
+// insertMail writes a message to the outbox as part of tx; reservationID is 0 for mail
+// that isn't about a reservation
+func insertMail(ctx context.Context, tx *sql.Tx, msg models.MailData, reservationID int, status string) error {
+	stmt := `INSERT INTO mail_messages (reservation_id, to_address, from_address, subject, content, text_content,
+			reply_to, return_path, list_unsubscribe, status, next_attempt_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`
+
+	var messageID int
+	now := time.Now()
+	err := tx.QueryRowContext(
+		ctx,
+		stmt,
+		sql.NullInt64{Int64: int64(reservationID), Valid: reservationID > 0},
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.Text,
+		msg.ReplyTo,
+		msg.ReturnPath,
+		msg.ListUnsubscribe,
+		status,
+		now,
+		now,
+		now,
+	).Scan(&messageID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO mail_attachments (message_id, filename, content_type, data, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+	for _, a := range msg.Attachments {
+		_, err = tx.ExecContext(ctx, stmt, messageID, a.Filename, a.ContentType, a.Data, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mailAttachments returns the files attached to a message, in the order they were attached
+func (m *postgresDbRepo) mailAttachments(ctx context.Context, messageID int) ([]models.MailAttachment, error) {
+	var attachments []models.MailAttachment
+
+	query := `SELECT filename, content_type, data FROM mail_attachments WHERE message_id = $1 ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, query, messageID)
+	if err != nil {
+		return attachments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.MailAttachment
+		err := rows.Scan(&a.Filename, &a.ContentType, &a.Data)
+		if err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return attachments, err
+	}
+	return attachments, nil
+}
+
+// InsertMail writes a message to the outbox, to be sent as soon as a worker gets to it
+func (m *postgresDbRepo) InsertMail(ctx context.Context, msg models.MailData) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMail(ctx, tx, msg, 0, models.MailPending)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseReservationMail lets the held mail about a reservation go out
+func (m *postgresDbRepo) ReleaseReservationMail(ctx context.Context, reservationID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE mail_messages SET status = $1, next_attempt_at = $2, updated_at = $2
+			WHERE reservation_id = $3 AND status = $4
+	`
+	_, err := m.DB.ExecContext(ctx, query, models.MailPending, time.Now(), reservationID, models.MailHeld)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimMail takes up to limit messages that are due, marks them as sending and counts the attempt.
+// A claim lasts for lease: a message still sending after that, because the worker died, is due again.
+// Workers skip each other's locked rows, so no message is claimed twice.
+func (m *postgresDbRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.MailMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var messages []models.MailMessage
+
+	now := time.Now()
+	query := `
+		UPDATE mail_messages SET status = $1, attempts = attempts + 1, next_attempt_at = $2, updated_at = $3
+			WHERE id IN (
+				SELECT id FROM mail_messages
+					WHERE status IN ($4, $1) AND next_attempt_at <= $3
+					ORDER BY next_attempt_at
+					LIMIT $5
+					FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, coalesce(reservation_id, 0), to_address, from_address, subject, content, text_content,
+				reply_to, return_path, list_unsubscribe, status, attempts, next_attempt_at, created_at, updated_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query, models.MailSending, now.Add(lease), now, models.MailPending, limit)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.MailMessage
+		err := rows.Scan(
+			&msg.ID,
+			&msg.ReservationID,
+			&msg.To,
+			&msg.From,
+			&msg.Subject,
+			&msg.Content,
+			&msg.Text,
+			&msg.ReplyTo,
+			&msg.ReturnPath,
+			&msg.ListUnsubscribe,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.NextAttemptAt,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	for i := range messages {
+		messages[i].Attachments, err = m.mailAttachments(ctx, messages[i].ID)
+		if err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+// MarkMailSent records that a message has been sent
+func (m *postgresDbRepo) MarkMailSent(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE mail_messages SET status = $1, sent_at = $2, updated_at = $2 WHERE id = $3`
+	_, err := m.DB.ExecContext(ctx, query, models.MailSent, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarkMailFailed records why an attempt to send a message failed, and saves the message's
+// new status and next attempt time
+func (m *postgresDbRepo) MarkMailFailed(ctx context.Context, msg models.MailMessage, reason string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `UPDATE mail_messages SET status = $1, next_attempt_at = $2, updated_at = $3 WHERE id = $4`
+	_, err = tx.ExecContext(ctx, query, msg.Status, msg.NextAttemptAt, now, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO mail_failures (message_id, attempt, error, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, stmt, msg.ID, msg.Attempts, reason, now, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AllMail returns the messages in the outbox with status, or all of them if status is empty, newest first
+func (m *postgresDbRepo) AllMail(ctx context.Context, status string) ([]models.MailMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var messages []models.MailMessage
+
+	query := `
+		SELECT id, coalesce(reservation_id, 0), to_address, from_address, subject,
+			status, attempts, next_attempt_at, coalesce(sent_at, '0001-01-01'), created_at, updated_at
+			FROM mail_messages
+			WHERE $1 = '' OR status = $1
+			ORDER BY created_at DESC
+	`
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.MailMessage
+		err := rows.Scan(
+			&msg.ID,
+			&msg.ReservationID,
+			&msg.To,
+			&msg.From,
+			&msg.Subject,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.NextAttemptAt,
+			&msg.SentAt,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+	return messages, nil
+}
+
+// GetMailByID returns a message from the outbox, with its attachments and the reasons its attempts failed, oldest first
+func (m *postgresDbRepo) GetMailByID(ctx context.Context, id int) (models.MailMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var msg models.MailMessage
+
+	query := `
+		SELECT id, coalesce(reservation_id, 0), to_address, from_address, subject, content, text_content,
+			reply_to, return_path, list_unsubscribe, status, attempts, next_attempt_at,
+			coalesce(sent_at, '0001-01-01'), created_at, updated_at
+			FROM mail_messages
+			WHERE id = $1
+	`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&msg.ID,
+		&msg.ReservationID,
+		&msg.To,
+		&msg.From,
+		&msg.Subject,
+		&msg.Content,
+		&msg.Text,
+		&msg.ReplyTo,
+		&msg.ReturnPath,
+		&msg.ListUnsubscribe,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.NextAttemptAt,
+		&msg.SentAt,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Attachments, err = m.mailAttachments(ctx, id)
+	if err != nil {
+		return msg, err
+	}
+
+	query = `
+		SELECT id, message_id, attempt, error, created_at
+			FROM mail_failures
+			WHERE message_id = $1
+			ORDER BY created_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return msg, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.MailFailure
+		err := rows.Scan(&f.ID, &f.MessageID, &f.Attempt, &f.Error, &f.CreatedAt)
+		if err != nil {
+			return msg, err
+		}
+		msg.Failures = append(msg.Failures, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ResendMail puts a dead message back in the outbox, with a fresh set of attempts.
+// It returns sql.ErrNoRows if there is no dead message with that id.
+func (m *postgresDbRepo) ResendMail(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE mail_messages SET status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+			WHERE id = $3 AND status = $4
+	`
+	result, err := m.DB.ExecContext(ctx, query, models.MailPending, time.Now(), id, models.MailDead)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
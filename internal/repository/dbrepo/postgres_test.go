@@ -0,0 +1,118 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/jjang65/booking-web-app/internal/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stuckDriver is a database that never answers; every query waits until its context is done
+type stuckDriver struct{}
+
+func (stuckDriver) Open(name string) (driver.Conn, error) {
+	return stuckConn{}, nil
+}
+
+type stuckConn struct{}
+
+func (stuckConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (stuckConn) Close() error {
+	return nil
+}
+
+func (stuckConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (stuckConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stuckConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func init() {
+	sql.Register("stuck", stuckDriver{})
+}
+
+func newStuckRepo(t *testing.T, timeout time.Duration) *postgresDbRepo {
+	db, err := sql.Open("stuck", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &postgresDbRepo{App: &config.AppConfig{DBTimeout: timeout}, DB: db}
+}
+
+func TestPostgresRepo_CancelledRequest(t *testing.T) {
+	repo := newStuckRepo(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := repo.GetRoomByID(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("query kept running for %s after the request was cancelled", elapsed)
+	}
+
+	// a request that is already gone doesn't reach the database at all
+	_, err = repo.AllActiveRooms(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for a cancelled request, got %v", err)
+	}
+}
+
+func TestPostgresRepo_QueryTimeout(t *testing.T) {
+	repo := newStuckRepo(t, 20*time.Millisecond)
+
+	start := time.Now()
+	err := repo.ReleaseReservationMail(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("query ran for %s with a 20ms timeout", elapsed)
+	}
+}
+
+func TestBlockRuns(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	var tests = []struct {
+		name     string
+		nights   []time.Time
+		expected [][2]time.Time
+	}{
+		{"nothing removed", nil, [][2]time.Time{{day(1), day(6)}}},
+		{"first night", []time.Time{day(1)}, [][2]time.Time{{day(2), day(6)}}},
+		{"last night", []time.Time{day(5)}, [][2]time.Time{{day(1), day(5)}}},
+		{"middle nights", []time.Time{day(4), day(2)}, [][2]time.Time{{day(1), day(2)}, {day(3), day(4)}, {day(5), day(6)}}},
+		{"every night", []time.Time{day(1), day(2), day(3), day(4), day(5)}, nil},
+		{"departure day", []time.Time{day(6)}, [][2]time.Time{{day(1), day(6)}}},
+	}
+
+	for _, e := range tests {
+		got := blockRuns(day(1), day(6), e.nights)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
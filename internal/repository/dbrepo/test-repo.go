@@ -1,74 +1,681 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/jjang65/booking-web-app/internal/lifecycle"
 	"github.com/jjang65/booking-web-app/internal/models"
+	"github.com/jjang65/booking-web-app/internal/repository"
+	"strings"
 	"time"
 )
 
-func (m *testDbRepo) AllUsers() bool {
-	return true
+// AllUsers returns all users
+func (m *testDbRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for _, id := range []int{1, 2, 3} {
+		u, _ := m.GetUserByID(ctx, id)
+		users = append(users, u)
+	}
+	return users, nil
 }
 
-// InsertReservation inserts a reservation into db that returns reservation_id and error
-func (m *testDbRepo) InsertReservation(res models.Reservation) (int, error) {
+// testTakenStart is the arrival date of a stay in room 1 that someone else has just booked, so
+// InsertReservation finds the room unavailable
+var testTakenStart = time.Date(2050, 12, 24, 0, 0, 0, 0, time.UTC)
+
+// InsertReservation inserts a reservation, and its room restriction, into db that returns reservation_id and error
+func (m *testDbRepo) InsertReservation(ctx context.Context, res models.Reservation, mail ...models.MailData) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("some error")
 	}
+	if res.Code == testTakenCode {
+		return 0, repository.ErrDuplicateCode
+	}
+	if res.RoomID == 1 && res.StartDate.Equal(testTakenStart) {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	// every new reservation gets id 1, so it takes over the held mail of the last one
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.held[1] = mail
 	return 1, nil
 }
 
-// InsertRoomRestriction inserts a room restriction into db
-func (m *testDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	// only if room id is 1000, fail
-	if r.RoomID == 1000 {
-		return errors.New("some error")
-	}
-	return nil
-}
+// testBookedStart and testBookedEnd are the dates of the reservation returned by GetReservationByID, in room 1
+var (
+	testBookedStart = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	testBookedEnd   = time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)
+)
 
 // SearchAvailabilityByDatesByRoomID returns ture if availability exists for roomID, and false if no availability
-func (m *testDbRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	return false, nil
+func (m *testDbRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	// room 1 is booked from testBookedStart to testBookedEnd
+	if roomID == 1 && start.Before(testBookedEnd) && end.After(testBookedStart) {
+		return false, nil
+	}
+	return true, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDbRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	var rooms []models.Room
+	for _, room := range testRooms {
+		if available, _ := m.SearchAvailabilityByDatesByRoomID(ctx, start, end, room.ID); available {
+			rooms = append(rooms, room)
+		}
+	}
 	return rooms, nil
 }
 
+// testRooms are the rooms known to the test repo
+var testRooms = []models.Room{
+	{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", Image: "generals-quarters.png", Active: true, ICalToken: "generals-token", NightlyRate: 10000},
+	{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", Image: "marjors-suite.png", Active: true, ICalToken: "majors-token", NightlyRate: 15000},
+}
+
 // GetRoomByID gets a room by id
-func (m *testDbRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDbRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
+	// there are no rooms above 1000, and the ones above 2 fail
+	if id > 1000 {
+		return room, sql.ErrNoRows
+	}
 	if id > 2 {
 		return room, errors.New("some error")
 	}
 
+	for _, x := range testRooms {
+		if x.ID == id {
+			return x, nil
+		}
+	}
 	return room, nil
 }
 
-func (m *testDbRepo) GetUserByID(id int) (models.User, error) {
+// GetUserByID returns a user by ID; ids 1, 2 and 3 are an owner, front desk and viewer, and 4 is deactivated
+func (m *testDbRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	var u models.User
+	switch id {
+	case 1:
+		u = models.User{ID: 1, FirstName: "Owen", LastName: "Owner", Password: "$2a$12$ownerhash", Email: "owner@here.com", AccessLevel: models.AccessLevelOwner, Active: true}
+	case 2:
+		u = models.User{ID: 2, FirstName: "Fran", LastName: "Desk", Password: "$2a$12$deskhash", Email: "desk@here.com", AccessLevel: models.AccessLevelFrontDesk, Active: true}
+	case 3:
+		u = models.User{ID: 3, FirstName: "Vic", LastName: "Viewer", Password: "$2a$12$viewerhash", Email: "viewer@here.com", AccessLevel: models.AccessLevelViewer, Active: true}
+	case 4:
+		u = models.User{ID: 4, FirstName: "Dee", LastName: "Activated", Password: "$2a$12$gonehash", Email: "gone@here.com", AccessLevel: models.AccessLevelFrontDesk, Active: false}
+	default:
+		return u, sql.ErrNoRows
+	}
 	return u, nil
 }
 
-func (m *testDbRepo) UpdateUser(u models.User) error {
+// GetUserByEmail returns a user by email address
+func (m *testDbRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	for _, id := range []int{1, 2, 3, 4} {
+		u, _ := m.GetUserByID(ctx, id)
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	if email == "broken@here.com" {
+		return models.User{}, errors.New("some error")
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// InsertUser inserts a user into the db
+func (m *testDbRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	// if the first name is "fail", fail
+	if u.FirstName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 5, nil
+}
+
+// UpdateUser updates a user in the db
+func (m *testDbRepo) UpdateUser(ctx context.Context, u models.User) error {
+	// if the first name is "fail", fail
+	if u.FirstName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateUserPassword stores a new, already hashed, password for a user
+func (m *testDbRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	// if the user id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
 	return nil
 }
 
-func (m *testDbRepo) Authenticate(email, password string) (int, string, error) {
+func (m *testDbRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
 	return 1, "", nil
 }
 
-func (m *testDbRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
+func (m *testDbRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	reservations := []models.Reservation{
+		{ID: 1, LastName: "Smith", StartDate: testBookedStart, EndDate: testBookedEnd, Status: models.ReservationConfirmed},
+		{ID: 2, LastName: "Jones", StartDate: testBookedStart, EndDate: testBookedEnd, Status: models.ReservationPending},
+		{ID: 50, LastName: "Brown", StartDate: testBookedStart, EndDate: testBookedEnd, Status: models.ReservationCancelled},
+	}
 	return reservations, nil
 }
 
-// AllNewReservations returns a slice of all reservations
-func (m *testDbRepo) AllNewReservations() ([]models.Reservation, error) {
+// AllNewReservations returns a slice of the reservations still waiting to be confirmed
+func (m *testDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
+
+// GetReservationByID returns one reservation by ID
+func (m *testDbRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	var res models.Reservation
+	// there are no reservations above 1000, and the ones above 100 fail
+	if id > 1000 {
+		return res, sql.ErrNoRows
+	}
+	if id > 100 {
+		return res, errors.New("some error")
+	}
+
+	res = models.Reservation{
+		ID:        id,
+		Code:      fmt.Sprintf("BK-%06d", id),
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "j@smith.com",
+		StartDate: testBookedStart,
+		EndDate:   testBookedEnd,
+		RoomID:    1,
+		Total:     12500,
+		Status:    models.ReservationConfirmed,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	// reservation 50 has been cancelled, and the guest of reservation 60 has checked in
+	switch id {
+	case 50:
+		res.Status = models.ReservationCancelled
+	case 60:
+		res.Status = models.ReservationCheckedIn
+	}
+	return res, nil
+}
+
+// testTakenCode is a confirmation code that another reservation already has
+const testTakenCode = "BK-TAKEN2"
+
+// testReservationCodes are the confirmation codes of the test reservations that behave differently;
+// any other code finds reservation 1
+var testReservationCodes = map[string]int{
+	"BK-UNPAID": 2,
+	"BK-CANCEL": 50,
+	"BK-CHECKN": 60,
+	"BK-FAILED": 100,
+	"BK-BROKEN": 1000,
+}
+
+// GetReservationByCode returns one reservation by its confirmation code
+func (m *testDbRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	if len(code) != len("BK-XXXXXX") || !strings.HasPrefix(code, "BK-") {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	id, ok := testReservationCodes[code]
+	if !ok {
+		id = 1
+	}
+	res, err := m.GetReservationByID(ctx, id)
+	res.Code = code
+	return res, err
+}
+
+// UpdateReservation updates a reservation in the db
+func (m *testDbRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	// only if the reservation id is 100, fail
+	if u.ID == 100 {
+		return errors.New("some error")
+	}
+	if u.RoomID == 1 && u.StartDate.Equal(testTakenStart) {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// DeleteReservation deletes one reservation by ID
+func (m *testDbRepo) DeleteReservation(ctx context.Context, id int) error {
+	// only if the reservation id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+
+	// the held mail goes with the reservation
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.held, id)
+	return nil
+}
+
+// UpdateReservationStatus moves a reservation to a new status, if the lifecycle allows it
+func (m *testDbRepo) UpdateReservationStatus(ctx context.Context, id int, status string, userID int, mail ...models.MailData) error {
+	// if the id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
+
+	res, err := m.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	// a reservation whose mail is still held has just been booked, and is waiting on its payment
+	m.mu.Lock()
+	if _, ok := m.held[id]; ok {
+		res.Status = models.ReservationPending
+	}
+	m.mu.Unlock()
+	err = lifecycle.Check(res.Status, status)
+	if err != nil {
+		return err
+	}
+	return m.deliver(mail...)
+}
+
+// GetReservationStatusChanges returns the status history of a reservation; reservation 1 was confirmed by user 1
+func (m *testDbRepo) GetReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error) {
+	if reservationID > 100 {
+		return nil, errors.New("some error")
+	}
+	if reservationID != 1 {
+		return nil, nil
+	}
+
+	return []models.ReservationStatusChange{
+		{
+			ID:            1,
+			ReservationID: 1,
+			FromStatus:    models.ReservationPending,
+			ToStatus:      models.ReservationConfirmed,
+			UserID:        1,
+			User:          models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+			CreatedAt:     time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}, nil
+}
+
+// AllRooms returns all rooms, including retired ones
+func (m *testDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	rooms := append([]models.Room{}, testRooms...)
+	rooms = append(rooms, models.Room{ID: 3, RoomName: "Old Cabin", Slug: "old-cabin", Active: false})
+	return rooms, nil
+}
+
+// AllActiveRooms returns all rooms that have not been retired
+func (m *testDbRepo) AllActiveRooms(ctx context.Context) ([]models.Room, error) {
+	return testRooms, nil
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *testDbRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	switch slug {
+	case "old-cabin":
+		return models.Room{ID: 3, RoomName: "Old Cabin", Slug: "old-cabin", Active: false}, nil
+	case "broken":
+		return models.Room{}, errors.New("some error")
+	}
+
+	for _, x := range testRooms {
+		if x.Slug == slug {
+			return x, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// InsertRoom inserts a room into the db, and returns its id
+func (m *testDbRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	// if the room name is "fail", fail
+	if r.RoomName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates a room in the db
+func (m *testDbRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	// if the room name is "fail", fail
+	if r.RoomName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateRoomICalToken replaces the secret token of a room's calendar feed
+func (m *testDbRepo) UpdateRoomICalToken(ctx context.Context, id int, token string) error {
+	// if the room id is 2, fail
+	if id == 2 {
+		return errors.New("some error")
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room overlapping a date range
+func (m *testDbRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	// if the room id is 100, fail
+	if roomID == 100 {
+		return restrictions, errors.New("some error")
+	}
+
+	// only room 1 has anything on it
+	if roomID != 1 {
+		return restrictions, nil
+	}
+
+	// a two night reservation and a one night block at the start of the range
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            1,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 2),
+		RoomID:        roomID,
+		ReservationID: 1,
+		RestrictionID: models.RestrictionReservation,
+		UpdatedAt:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            2,
+		StartDate:     start.AddDate(0, 0, 3),
+		EndDate:       start.AddDate(0, 0, 4),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+		UpdatedAt:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts an owner block for a single night
+func (m *testDbRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	// if the room id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
+	// room 1 is booked from testBookedStart to testBookedEnd
+	if id == 1 && startDate.Before(testBookedEnd) && !startDate.Before(testBookedStart) {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// DeleteBlockNights takes nights out of an owner block
+func (m *testDbRepo) DeleteBlockNights(ctx context.Context, id int, nights []time.Time) error {
+	// if the block id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetExternalRestrictionsForRoom returns all the restrictions imported from other platforms for a room
+func (m *testDbRepo) GetExternalRestrictionsForRoom(ctx context.Context, roomID int) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	// if the room id is 100, fail
+	if roomID == 100 {
+		return restrictions, errors.New("some error")
+	}
+
+	// two earlier imports; the test feeds move the first and drop the second
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            10,
+		StartDate:     time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 3, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionExternal,
+		ExternalUID:   "moved@partner",
+	})
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            11,
+		StartDate:     time.Date(2050, 4, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 4, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionExternal,
+		ExternalUID:   "removed@partner",
+	})
+	return restrictions, nil
+}
+
+// SyncExternalRestrictions inserts, updates and deletes a room's external restrictions in one transaction
+func (m *testDbRepo) SyncExternalRestrictions(ctx context.Context, roomID int, inserts, updates []models.RoomRestriction, deleteIDs []int) error {
+	// if the room id is 1000, fail
+	if roomID == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetRoomRates returns the rates of a room; room 1 costs more on weekends and on New Year's Eve in 2050
+func (m *testDbRepo) GetRoomRates(ctx context.Context, roomID int) ([]models.RoomRate, error) {
+	if roomID > 2 {
+		return nil, errors.New("some error")
+	}
+	if roomID != 1 {
+		return nil, nil
+	}
+
+	return []models.RoomRate{
+		{
+			ID:          1,
+			RoomID:      1,
+			Name:        "Weekend",
+			StartDate:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC),
+			Weekdays:    1<<time.Friday | 1<<time.Saturday,
+			NightlyRate: 12500,
+		},
+		{
+			ID:          2,
+			RoomID:      1,
+			Name:        "New Year's Eve",
+			StartDate:   time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC),
+			NightlyRate: 20000,
+		},
+	}, nil
+}
+
+// InsertRoomRate inserts a room rate into the db
+func (m *testDbRepo) InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error) {
+	// if the name is "fail", fail
+	if r.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// DeleteRoomRate deletes one of a room's rates
+func (m *testDbRepo) DeleteRoomRate(ctx context.Context, roomID, id int) error {
+	// if the rate id is 100, fail
+	if id == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertPayment inserts a payment into the db
+func (m *testDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	return 1, nil
+}
+
+// UpdatePayment records what happened to a payment at the gateway
+func (m *testDbRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments made for a reservation; reservation 1 was paid in full
+func (m *testDbRepo) GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	if reservationID > 100 {
+		return nil, errors.New("some error")
+	}
+	if reservationID != 1 {
+		return nil, nil
+	}
+
+	return []models.Payment{
+		{
+			ID:              1,
+			ReservationID:   1,
+			Amount:          12500,
+			Status:          models.PaymentCaptured,
+			AuthorizationID: "fake_auth_1",
+		},
+	}, nil
+}
+
+// GetUnsettledPayments returns a payment for reservation 1 that the gateway didn't answer for
+func (m *testDbRepo) GetUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error) {
+	return []models.Payment{
+		{
+			ID:            2,
+			ReservationID: 1,
+			Amount:        12500,
+			Status:        models.PaymentPending,
+			Error:         "payment gateway timed out",
+		},
+	}, nil
+}
+
+// GetPaymentsWithRefundDue returns a payment of reservation 1 that still has half of it to be refunded
+func (m *testDbRepo) GetPaymentsWithRefundDue(ctx context.Context) ([]models.Payment, error) {
+	return []models.Payment{
+		{
+			ID:              1,
+			ReservationID:   1,
+			Amount:          12500,
+			RefundDue:       6250,
+			Status:          models.PaymentCaptured,
+			AuthorizationID: "fake_auth_1",
+			Error:           "payment gateway timed out",
+		},
+	}, nil
+}
+
+// deliver hands mail to the app's mailer, if it has one
+func (m *testDbRepo) deliver(mail ...models.MailData) error {
+	if m.App.Mailer == nil {
+		return nil
+	}
+	for _, msg := range mail {
+		err := m.App.Mailer.Send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertMail writes a message to the outbox, which the test repo delivers straight away
+func (m *testDbRepo) InsertMail(ctx context.Context, msg models.MailData) error {
+	return m.deliver(msg)
+}
+
+// ReleaseReservationMail delivers the held mail about a reservation
+func (m *testDbRepo) ReleaseReservationMail(ctx context.Context, reservationID int) error {
+	m.mu.Lock()
+	mail := m.held[reservationID]
+	delete(m.held, reservationID)
+	m.mu.Unlock()
+
+	return m.deliver(mail...)
+}
+
+// ClaimMail takes the messages that are due; the test outbox is always empty
+func (m *testDbRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.MailMessage, error) {
+	return nil, nil
+}
+
+// MarkMailSent records that a message has been sent
+func (m *testDbRepo) MarkMailSent(ctx context.Context, id int) error {
+	return nil
+}
+
+// MarkMailFailed records why an attempt to send a message failed
+func (m *testDbRepo) MarkMailFailed(ctx context.Context, msg models.MailMessage, reason string) error {
+	return nil
+}
+
+// testMail is the test outbox: message 1 gave up, and message 2 was sent
+var testMail = []models.MailMessage{
+	{
+		ID:            1,
+		ReservationID: 1,
+		MailData: models.MailData{
+			To:      "john@smith.com",
+			From:    "me@here.com",
+			Subject: "Reservation Confirmation",
+			Content: "This is to confirm your reservation",
+		},
+		Status:   models.MailDead,
+		Attempts: 8,
+		Failures: []models.MailFailure{
+			{ID: 1, MessageID: 1, Attempt: 1, Error: "dial tcp: connection refused"},
+		},
+	},
+	{
+		ID: 2,
+		MailData: models.MailData{
+			To:      "jane@smith.com",
+			From:    "me@here.com",
+			Subject: "Password Reset",
+			Content: "Someone asked to reset the password for your account",
+		},
+		Status:   models.MailSent,
+		Attempts: 1,
+	},
+}
+
+// AllMail returns the messages in the outbox with status, or all of them if status is empty
+func (m *testDbRepo) AllMail(ctx context.Context, status string) ([]models.MailMessage, error) {
+	var messages []models.MailMessage
+	for _, x := range testMail {
+		if status == "" || x.Status == status {
+			messages = append(messages, x)
+		}
+	}
+	return messages, nil
+}
+
+// GetMailByID returns a message from the outbox; ids over 100 fail
+func (m *testDbRepo) GetMailByID(ctx context.Context, id int) (models.MailMessage, error) {
+	if id > 100 {
+		return models.MailMessage{}, errors.New("some error")
+	}
+	for _, x := range testMail {
+		if x.ID == id {
+			return x, nil
+		}
+	}
+	return models.MailMessage{}, sql.ErrNoRows
+}
+
+// ResendMail puts a dead message back in the outbox; message 100 can't be saved
+func (m *testDbRepo) ResendMail(ctx context.Context, id int) error {
+	if id == 100 {
+		return errors.New("some error")
+	}
+	msg, err := m.GetMailByID(ctx, id)
+	if err != nil || msg.Status != models.MailDead {
+		return sql.ErrNoRows
+	}
+	return nil
+}
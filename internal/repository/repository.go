@@ -1,23 +1,70 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"github.com/jjang65/booking-web-app/internal/models"
 	"time"
 )
 
+// ErrDuplicateCode is returned by InsertReservation when the reservation's confirmation code is already taken
+var ErrDuplicateCode = errors.New("confirmation code is already in use")
+
+// ErrRoomUnavailable is returned when a reservation's room is already taken for some of its nights
+var ErrRoomUnavailable = errors.New("room is not available for those dates")
+
+// DatabaseRepo is the application's storage. Every method takes the context of the request it
+// serves, so a query stops when the request is cancelled.
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers(ctx context.Context) ([]models.User, error)
+
+	InsertReservation(ctx context.Context, res models.Reservation, mail ...models.MailData) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	AllActiveRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, r models.Room) (int, error)
+	UpdateRoom(ctx context.Context, r models.Room) error
+	UpdateRoomICalToken(ctx context.Context, id int, token string) error
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error
+	DeleteBlockNights(ctx context.Context, id int, nights []time.Time) error
+	GetExternalRestrictionsForRoom(ctx context.Context, roomID int) ([]models.RoomRestriction, error)
+	SyncExternalRestrictions(ctx context.Context, roomID int, inserts, updates []models.RoomRestriction, deleteIDs []int) error
+	GetRoomRates(ctx context.Context, roomID int) ([]models.RoomRate, error)
+	InsertRoomRate(ctx context.Context, r models.RoomRate) (int, error)
+	DeleteRoomRate(ctx context.Context, roomID, id int) error
+
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error
+	Authenticate(ctx context.Context, email, password string) (int, string, error)
 
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateReservationStatus(ctx context.Context, id int, status string, userID int, mail ...models.MailData) error
+	GetReservationStatusChanges(ctx context.Context, reservationID int) ([]models.ReservationStatusChange, error)
 
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, password string) (int, string, error)
+	InsertPayment(ctx context.Context, p models.Payment) (int, error)
+	UpdatePayment(ctx context.Context, p models.Payment) error
+	GetPaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error)
+	GetUnsettledPayments(ctx context.Context, before time.Time) ([]models.Payment, error)
+	GetPaymentsWithRefundDue(ctx context.Context) ([]models.Payment, error)
 
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	InsertMail(ctx context.Context, msg models.MailData) error
+	ReleaseReservationMail(ctx context.Context, reservationID int) error
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.MailMessage, error)
+	MarkMailSent(ctx context.Context, id int) error
+	MarkMailFailed(ctx context.Context, msg models.MailMessage, reason string) error
+	AllMail(ctx context.Context, status string) ([]models.MailMessage, error)
+	GetMailByID(ctx context.Context, id int) (models.MailMessage, error)
+	ResendMail(ctx context.Context, id int) error
 }
@@ -0,0 +1,69 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature doesn't match
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when a token has a valid signature but is past its expiry
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Signer creates and verifies tamper-proof tokens that carry a payload and an expiry time
+type Signer struct {
+	key []byte
+}
+
+// New returns a Signer using key as the HMAC secret
+func New(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns a url-safe token holding data that is valid until expires
+func (s *Signer) Sign(data string, expires time.Time) string {
+	payload := data + "|" + strconv.FormatInt(expires.Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + s.signature(encoded)
+}
+
+// Verify checks the signature and expiry of token and returns the data it was signed with
+func (s *Signer) Verify(token string) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.signature(encoded))) {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	i := strings.LastIndex(string(payload), "|")
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(string(payload[i+1:]), 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return "", ErrExpiredToken
+	}
+
+	return string(payload[:i]), nil
+}
+
+func (s *Signer) signature(encoded string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,57 @@
+package signer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	s := New([]byte("secret"))
+
+	token := s.Sign("reset|1|abc", time.Now().Add(time.Hour))
+	data, err := s.Verify(token)
+	if err != nil {
+		t.Error("valid token failed verification:", err)
+	}
+	if data != "reset|1|abc" {
+		t.Errorf("wrong data from token: got %q", data)
+	}
+}
+
+func TestSigner_Expired(t *testing.T) {
+	s := New([]byte("secret"))
+
+	token := s.Sign("reset|1|abc", time.Now().Add(-time.Minute))
+	_, err := s.Verify(token)
+	if !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected expired token error, got %v", err)
+	}
+}
+
+func TestSigner_Tampered(t *testing.T) {
+	s := New([]byte("secret"))
+	token := s.Sign("reset|1|abc", time.Now().Add(time.Hour))
+
+	var tests = []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", "cmVzZXR8MXxhYmN8OTk5OTk5OTk5OQ"},
+		{"changed payload", "cmVzZXR8MnxhYmN8OTk5OTk5OTk5OQ" + token[len(token)-44:]},
+		{"changed signature", token[:len(token)-1] + "x"},
+	}
+
+	for _, e := range tests {
+		if _, err := s.Verify(e.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected invalid token error, got %v", e.name, err)
+		}
+	}
+
+	// a token signed with another key must not verify
+	other := New([]byte("other secret"))
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token verified with the wrong key: %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+// Package migrations holds the fizz migrations of the schema, so the server can apply them itself
+package migrations
+
+import "embed"
+
+// FS holds the migration files
+//
+//go:embed *.fizz
+var FS embed.FS